@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"pseudonymous/config"
+	"pseudonymous/fhir"
+)
+
+func NewCheckCmd() *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "check",
+		Short: "Check the connectivity to MongoDB, the FHIR pseudonymizer and gPAS",
+		// the problems found are printed instead
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			projects, err := validateCmd()
+			if err != nil {
+				slog.Error("Failed to validate command flags", "error", err.Error())
+				return err
+			}
+
+			config.ConfigureLogger(*cfg)
+			clients := fhir.NewClients()
+			defer func() { _ = clients.Close() }()
+
+			failed := false
+			for _, p := range projects {
+				if err = check(cmd, p, clients); err != nil {
+					_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "project %s:\n%s\n", p.name, err.Error())
+					failed = true
+				}
+			}
+			if failed {
+				return fhir.ErrCheckFailed
+			}
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "all checks passed")
+			return nil
+		},
+	}
+}
+
+func check(cmd *cobra.Command, p project, clients *fhir.Clients) error {
+	processor, err := clients.NewProcessor(p.cfg, p.name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = processor.Close() }()
+
+	return processor.Check(cmd.Context())
+}
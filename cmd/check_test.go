@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCheckCmd_InvalidConfig(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+
+	rootCmd.SetArgs([]string{"check", "-p", "test"})
+
+	err := rootCmd.Execute()
+
+	assert.ErrorContains(t, err, "invalid config")
+}
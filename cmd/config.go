@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"pseudonymous/config"
+)
+
+func NewConfigCmd() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Validate or print the effective configuration",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Validate the configuration",
+		// the problems found are printed instead
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			// projects are validated with their overrides
+			var err error
+			if len(projectNames) > 0 || len(cfg.Projects) > 0 {
+				_, err = validateCmd()
+			} else {
+				err = cfg.Validate()
+			}
+			if err != nil {
+				_, _ = fmt.Fprintln(cmd.ErrOrStderr(), err.Error())
+				return usageError{errors.New("invalid config")}
+			}
+			_, _ = fmt.Fprintln(cmd.OutOrStdout(), "config is valid")
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "print",
+		Short: "Print the effective configuration with secrets masked, per project if set by -p",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			configs := []*config.AppConfig{cfg}
+			if len(projectNames) > 0 {
+				configs = nil
+				for _, name := range projectNames {
+					c, err := projectConfig(name)
+					if err != nil {
+						return err
+					}
+					configs = append(configs, c)
+				}
+			}
+
+			enc := yaml.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent(2)
+			defer func() { _ = enc.Close() }()
+			for _, c := range configs {
+				if err := enc.Encode(c.Redacted()); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+
+	return cmd
+}
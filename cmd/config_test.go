@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfigValidateCmd(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./app.yaml"
+	out := new(bytes.Buffer)
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"config", "validate"})
+
+	err := rootCmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "config is valid\n", out.String())
+}
+
+func TestConfigValidateCmd_Invalid(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+	errOut := new(bytes.Buffer)
+	rootCmd.SetErr(errOut)
+	defer rootCmd.SetErr(nil)
+
+	rootCmd.SetArgs([]string{"config", "validate"})
+
+	err := rootCmd.Execute()
+
+	assert.EqualError(t, err, "invalid config")
+	assert.Contains(t, errOut.String(), "fhir.pseudonymizer.url is required")
+}
+
+func TestConfigPrintCmd(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/secrets.yaml"
+	t.Setenv("TEST_LOG_LEVEL", "debug")
+	out := new(bytes.Buffer)
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"config", "print"})
+
+	err := rootCmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "log-level: debug")
+	assert.Contains(t, out.String(), "password: xxxxx")
+	assert.NotContains(t, out.String(), "secret")
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"pseudonymous/fhir"
+	"pseudonymous/ttp"
+)
+
+// exit codes per failure class
+const (
+	exitOk = 0
+	// exitFailure is a failed run or operation
+	exitFailure = 1
+	// exitUsage is an invalid flag, project or config
+	exitUsage = 2
+	// exitUnavailable is a failed check of MongoDB, the pseudonymizer or gPAS
+	exitUnavailable = 3
+	// exitMissingDomains is a failed verification of the gPAS domains
+	exitMissingDomains = 4
+	// exitInterrupted is a run interrupted by SIGINT or SIGTERM
+	exitInterrupted = 130
+)
+
+// usageError is an invalid command line or config
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code of the failure class of err
+func exitCode(err error) int {
+	var usage usageError
+	switch {
+	case err == nil:
+		return exitOk
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, fhir.ErrCheckFailed), errors.Is(err, fhir.ErrPseudonymizerUnavailable):
+		return exitUnavailable
+	case errors.Is(err, ttp.ErrMissingDomains):
+		return exitMissingDomains
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"pseudonymous/fhir"
+	"pseudonymous/ttp"
+	"testing"
+	"time"
+)
+
+func TestExitCode(t *testing.T) {
+
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{nil, exitOk},
+		{errors.New("failed"), exitFailure},
+		{usageError{errors.New("project name is empty")}, exitUsage},
+		{fmt.Errorf("%w: source database test does not exist", fhir.ErrCheckFailed), exitUnavailable},
+		{fmt.Errorf("processing aborted: %w", fhir.ErrPseudonymizerUnavailable), exitUnavailable},
+		{fmt.Errorf("%w: test-patient", ttp.ErrMissingDomains), exitMissingDomains},
+		{fmt.Errorf("processing interrupted: %w", context.Canceled), exitInterrupted},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.code, exitCode(c.err))
+	}
+}
+
+func TestExitCodeMultipleProjects(t *testing.T) {
+
+	status := func(project string, err error) fhir.RunStatus {
+		return fhir.NewRunStatus(project, time.Now(), fhir.ProcessResult{}, err)
+	}
+	checkFailed := fmt.Errorf("%w: source database a does not exist", fhir.ErrCheckFailed)
+	unavailable := fmt.Errorf("processing aborted: %w", fhir.ErrPseudonymizerUnavailable)
+	missingDomains := fmt.Errorf("%w: test-patient", ttp.ErrMissingDomains)
+	interrupted := fmt.Errorf("processing interrupted: %w", context.Canceled)
+
+	cases := []struct {
+		projects []fhir.RunStatus
+		code     int
+	}{
+		{[]fhir.RunStatus{status("a", nil), status("b", nil)}, exitOk},
+		{[]fhir.RunStatus{status("a", errors.New("failed")), status("b", nil)}, exitFailure},
+		{[]fhir.RunStatus{status("a", checkFailed), status("b", nil)}, exitUnavailable},
+		{[]fhir.RunStatus{status("a", nil), status("b", unavailable)}, exitUnavailable},
+		{[]fhir.RunStatus{status("a", missingDomains), status("b", interrupted)}, exitMissingDomains},
+		{[]fhir.RunStatus{status("a", errors.New("failed")), status("b", interrupted)}, exitFailure},
+		{[]fhir.RunStatus{status("a", interrupted), status("b", nil)}, exitInterrupted},
+	}
+
+	for i, c := range cases {
+		assert.Equal(t, c.code, exitCode(fhir.NewReport(time.Now(), c.projects).Err()), "case %d", i)
+	}
+}
+
+func TestExecuteCommand_InvalidFlagExitCode(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+
+	rootCmd.SetArgs([]string{"run", "--invalid-flag"})
+
+	err := rootCmd.Execute()
+
+	assert.EqualError(t, err, "unknown flag: --invalid-flag")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/spf13/cobra"
+	"io"
+	"log/slog"
+	"os"
+	"pseudonymous/config"
+	"pseudonymous/fhir"
+)
+
+var (
+	outputFile string
+	resolve    bool
+)
+
+func NewExportCmd() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export pseudonyms of the destination database per gPAS domain as CSV",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			projects, err := validateCmd()
+			if err == nil && len(projects) > 1 {
+				err = usageError{errors.New("export supports a single project only")}
+			}
+			if err != nil {
+				slog.Error("Failed to validate command flags", "error", err.Error())
+				return err
+			}
+			cfg := projects[0].cfg
+
+			config.ConfigureLogger(*cfg)
+			shutdown, err := configureTracing()
+			if err != nil {
+				return err
+			}
+			defer shutdown()
+
+			e, err := fhir.NewExporter(cfg, projects[0].name, resolve)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = e.Close() }()
+
+			var w io.Writer = os.Stdout
+			if outputFile != "" {
+				f, err := os.Create(outputFile)
+				if err != nil {
+					slog.Error("Failed to create output file", "file", outputFile, "error", err.Error())
+					return err
+				}
+				defer func() { _ = f.Close() }()
+				w = f
+			}
+
+			_, err = e.Export(w)
+			if err != nil {
+				slog.Error("Export exited", "error", err.Error())
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "output CSV file (default is stdout)")
+	cmd.Flags().BoolVar(&resolve, "resolve", false, "resolve original values via gPAS")
+
+	return cmd
+}
@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestExportCmd_EmptyProject(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+
+	rootCmd.SetArgs([]string{"export", "-p", ""})
+
+	err := rootCmd.Execute()
+
+	assert.EqualError(t, err, "project name is empty")
+}
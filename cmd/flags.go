@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"pseudonymous/config"
+	"slices"
+	"strings"
+)
+
+// configFlags maps the flags of the main settings to their config keys
+var configFlags = map[string]string{
+	"log-level":          "app.log-level",
+	"concurrency":        "app.concurrency",
+	"write-concurrency":  "app.write-concurrency",
+	"parallel-projects":  "app.parallel-projects",
+	"preflight":          "app.preflight",
+	"status-file":        "app.status-file",
+	"batch-size":         "fhir.provider.mongodb.batch-size",
+	"readers":            "fhir.provider.mongodb.readers",
+	"partitions":         "fhir.provider.mongodb.partitions",
+	"mongodb-connection": "fhir.provider.mongodb.connection",
+	"pseudonymizer-url":  "fhir.pseudonymizer.url",
+	"rules":              "fhir.pseudonymizer.rules",
+	"gpas-url":           "gpas.url",
+	"gpas-psn-url":       "gpas.psn-url",
+}
+
+// addConfigFlags adds the flags of the main settings and --set for any other
+// config key
+func addConfigFlags(flags *pflag.FlagSet) {
+	flags.String("log-level", "", "log level: error, warn, info or debug (app.log-level)")
+	flags.Int("concurrency", 0, "number of concurrent workers (app.concurrency)")
+	flags.Int("write-concurrency", 0, "number of concurrent writers (app.write-concurrency)")
+	flags.Int("parallel-projects", 0, "number of projects processed at the same time (app.parallel-projects)")
+	flags.Bool("preflight", false, "check connectivity before processing (app.preflight)")
+	flags.String("status-file", "", "file to save the run status to (app.status-file)")
+	flags.Int("batch-size", 0, "MongoDB cursor batch size (fhir.provider.mongodb.batch-size)")
+	flags.Int("readers", 0, "number of concurrent MongoDB readers (fhir.provider.mongodb.readers)")
+	flags.Int("partitions", 0, "number of read partitions per collection (fhir.provider.mongodb.partitions)")
+	flags.String("mongodb-connection", "", "MongoDB connection string (fhir.provider.mongodb.connection)")
+	flags.String("pseudonymizer-url", "", "FHIR pseudonymizer URL (fhir.pseudonymizer.url)")
+	flags.String("rules", "", "anonymization rules file of the pseudonymizer (fhir.pseudonymizer.rules)")
+	flags.String("gpas-url", "", "gPAS domain service URL (gpas.url)")
+	flags.String("gpas-psn-url", "", "gPAS PSN service URL (gpas.psn-url)")
+	flags.StringArray("set", nil, "set any config key, e.g. --set gpas.retry.count=3 (may be repeated)")
+}
+
+// bindFlags binds the config flags, so they take precedence over environment
+// variables and the config file if set
+func bindFlags(flags *pflag.FlagSet) error {
+	for name, key := range configFlags {
+		if err := viper.BindPFlag(key, flags.Lookup(name)); err != nil {
+			return err
+		}
+	}
+
+	values, err := flags.GetStringArray("set")
+	if err != nil {
+		return err
+	}
+	keys := config.Keys()
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("invalid --set %s, use key=value", v)
+		}
+		if !slices.Contains(keys, key) {
+			return fmt.Errorf("unknown config key %s of --set", key)
+		}
+		viper.Set(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"pseudonymous/config"
+	"slices"
+	"testing"
+)
+
+func TestConfigFlagsKeys(t *testing.T) {
+	keys := config.Keys()
+
+	for name, key := range configFlags {
+		assert.True(t, slices.Contains(keys, key), "unknown key %s of flag %s", key, name)
+		assert.NotNil(t, rootCmd.PersistentFlags().Lookup(name), "flag %s is not defined", name)
+	}
+}
+
+func TestInitConfigPrecedence(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+	defer func() { cfgFile = "" }()
+
+	// file only
+	initConfig()
+	assert.Equal(t, 10000, cfg.Fhir.Provider.MongoDb.BatchSize)
+	assert.Equal(t, "info", cfg.App.LogLevel)
+
+	// env overrides the file
+	t.Setenv("FHIR_PROVIDER_MONGODB_BATCH_SIZE", "2000")
+	t.Setenv("APP_LOG_LEVEL", "warn")
+	initConfig()
+	assert.Equal(t, 2000, cfg.Fhir.Provider.MongoDb.BatchSize)
+
+	// flags override env
+	flags := rootCmd.PersistentFlags()
+	_ = flags.Set("batch-size", "3000")
+	_ = flags.Set("pseudonymizer-url", "http://localhost:5000/fhir")
+	_ = flags.Set("set", "gpas.retry.count=3")
+	initConfig()
+	assert.Equal(t, 3000, cfg.Fhir.Provider.MongoDb.BatchSize)
+	assert.Equal(t, "http://localhost:5000/fhir", cfg.Fhir.Pseudonymizer.Url)
+	assert.Equal(t, 3, cfg.Gpas.Retry.Count)
+	// unset flags don't override
+	assert.Equal(t, "warn", cfg.App.LogLevel)
+	assert.Equal(t, "dummyConnection", cfg.Fhir.Provider.MongoDb.Connection)
+
+	setProjectDir()
+}
+
+func TestBindFlagsInvalidSet(t *testing.T) {
+	setProjectDir()
+	flags := rootCmd.PersistentFlags()
+
+	_ = flags.Set("set", "gpas.retry.counts=3")
+	assert.EqualError(t, bindFlags(flags), "unknown config key gpas.retry.counts of --set")
+
+	setProjectDir()
+	_ = flags.Set("set", "gpas.retry.count")
+	assert.EqualError(t, bindFlags(flags), "invalid --set gpas.retry.count, use key=value")
+
+	setProjectDir()
+}
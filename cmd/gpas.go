@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"pseudonymous/config"
+	"pseudonymous/ttp"
+	"strings"
+	"text/tabwriter"
+)
+
+func NewGpasCmd() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "gpas",
+		Short: "Manage the gPAS domains of the projects",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "setup",
+		Short: "Create the gPAS domains of the projects",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			projects, err := validateCmd()
+			if err != nil {
+				slog.Error("Failed to validate command flags", "error", err.Error())
+				return err
+			}
+
+			config.ConfigureLogger(*cfg)
+			for _, p := range projects {
+				gpas := ttp.NewGpasClient(p.cfg.Gpas)
+				if gpas == nil {
+					return errors.New("failed to initialize gPAS client")
+				}
+				if err = gpas.SetupDomains(cmd.Context(), p.name); err != nil {
+					return err
+				}
+				slog.Info("gPAS domains initialized", "project", p.name)
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "domains",
+		Short: "List the gPAS domains of the projects in creation order",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			projects, err := validateCmd()
+			if err != nil {
+				slog.Error("Failed to validate command flags", "error", err.Error())
+				return err
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintln(w, "DOMAIN\tPREFIX\tPARENTS")
+			for _, p := range projects {
+				gpas := ttp.NewGpasClient(p.cfg.Gpas)
+				if gpas == nil {
+					return errors.New("failed to initialize gPAS client")
+				}
+				domains, err := gpas.Domains(p.name)
+				if err != nil {
+					return usageError{err}
+				}
+				for _, d := range domains {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", d.Name, d.Config.PsnPrefix, strings.Join(d.ParentDomainNames, ","))
+				}
+			}
+			return w.Flush()
+		},
+	})
+
+	return cmd
+}
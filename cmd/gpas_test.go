@@ -0,0 +1,24 @@
+package cmd
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGpasDomainsCmd(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/projects.yaml"
+	t.Setenv("GPAS_DOMAINS_CONFIG[0]", "patient:PAT")
+	out := new(bytes.Buffer)
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+
+	rootCmd.SetArgs([]string{"gpas", "domains", "-p", "test"})
+
+	err := rootCmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Regexp(t, `test\s+PSN-TEST-\s*\n`, out.String())
+	assert.Regexp(t, `test-patient\s+PSN-TEST-PAT-\s+test\n`, out.String())
+}
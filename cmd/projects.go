@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"log/slog"
+	"pseudonymous/config"
+	"pseudonymous/fhir"
+	"slices"
+	"sync"
+	"time"
+)
+
+// project is a project to process with its effective config
+type project struct {
+	name string
+	cfg  *config.AppConfig
+}
+
+// validateCmd returns the projects set by the -p flags or, if not set, the
+// configured projects. The config of each project is validated. Errors are
+// usage errors.
+func validateCmd() ([]project, error) {
+	projects, err := selectProjects()
+	if err != nil {
+		return nil, usageError{err}
+	}
+	return projects, nil
+}
+
+func selectProjects() ([]project, error) {
+	names := projectNames
+	if len(names) == 0 {
+		for _, p := range cfg.Projects {
+			names = append(names, p.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no project set, use the -p flag or the projects config")
+	}
+
+	projects := make([]project, 0, len(names))
+	var errs []error
+	for i, name := range names {
+		if name == "" {
+			return nil, errors.New("project name is empty")
+		}
+		if slices.Contains(names[:i], name) {
+			return nil, fmt.Errorf("duplicate project %s", name)
+		}
+
+		c, err := projectConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid config of project %s: %w", name, err))
+		}
+		projects = append(projects, project{name: name, cfg: c})
+	}
+
+	return projects, errors.Join(errs...)
+}
+
+// projectConfig returns a copy of the app config with the overrides of the
+// project applied
+func projectConfig(name string) (*config.AppConfig, error) {
+	i := slices.IndexFunc(cfg.Projects, func(p config.Project) bool { return p.Name == name })
+	if i < 0 || len(cfg.Projects[i].Config) == 0 {
+		c := *cfg
+		return &c, nil
+	}
+
+	// the overrides are merged into the effective settings of file, env and flags
+	v := viper.New()
+	if err := v.MergeConfigMap(viper.AllSettings()); err != nil {
+		return nil, err
+	}
+	if err := v.MergeConfigMap(cfg.Projects[i].Config); err != nil {
+		return nil, fmt.Errorf("invalid config of project %s: %w", name, err)
+	}
+
+	c := new(config.AppConfig)
+	if err := v.Unmarshal(c, viper.DecodeHook(config.DecodeHook())); err != nil {
+		return nil, fmt.Errorf("invalid config of project %s: %w", name, err)
+	}
+	if err := c.ResolveSecrets(); err != nil {
+		return nil, fmt.Errorf("invalid config of project %s: %w", name, err)
+	}
+	return c, nil
+}
+
+// runProjects processes the projects, up to app.parallel-projects at the same
+// time. Projects are started in order and share their clients. For multiple
+// projects, the combined report is saved to the status file.
+func runProjects(ctx context.Context, projects []project) error {
+	if len(projects) == 1 {
+		_, err := runProject(ctx, projects[0], nil)
+		return err
+	}
+
+	clients := fhir.NewClients()
+	defer func() { _ = clients.Close() }()
+
+	start := time.Now()
+	statuses := make([]fhir.RunStatus, len(projects))
+	running := make(chan struct{}, max(1, cfg.App.ParallelProjects))
+	wg := new(sync.WaitGroup)
+	for i, p := range projects {
+		// the report replaces the status files of the projects
+		p.cfg.App.StatusFile = ""
+		// progress bars of parallel projects would overwrite each other
+		if cfg.App.ParallelProjects > 1 {
+			p.cfg.App.Progress.Bar = false
+		}
+
+		select {
+		case running <- struct{}{}:
+		case <-ctx.Done():
+			statuses[i] = fhir.NewRunStatus(p.name, time.Now(), fhir.ProcessResult{}, ctx.Err())
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-running }()
+			statuses[i], _ = runProject(ctx, p, clients)
+		}()
+	}
+	wg.Wait()
+
+	report := fhir.NewReport(start, statuses)
+	if err := report.Save(cfg.App.StatusFile); err != nil {
+		slog.Error("Failed to save run report", "file", cfg.App.StatusFile, "error", err.Error())
+	}
+	return report.Err()
+}
+
+// runProject processes a project, with the shared clients if not nil
+func runProject(ctx context.Context, p project, clients *fhir.Clients) (fhir.RunStatus, error) {
+	start := time.Now()
+
+	processor, err := clients.NewProcessor(p.cfg, p.name)
+	if err != nil {
+		slog.Error("Failed to create processor", "project", p.name, "error", err.Error())
+		return fhir.NewRunStatus(p.name, start, fhir.ProcessResult{}, err), err
+	}
+	defer func() { _ = processor.Close() }()
+
+	result, err := processor.Run(ctx)
+	if err != nil {
+		slog.Error("Processor run exited", "project", p.name, "error", err.Error())
+	}
+	return fhir.NewRunStatus(p.name, start, result, err), err
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"pseudonymous/fhir"
+	"testing"
+)
+
+func TestValidateCmdProjects(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/projects.yaml"
+	defer func() { cfgFile = "" }()
+
+	initConfig()
+	projects, err := validateCmd()
+
+	assert.NoError(t, err)
+	assert.Len(t, projects, 2)
+	assert.Equal(t, "study-a", projects[0].name)
+	assert.Equal(t, 5, projects[0].cfg.App.Concurrency)
+	assert.Equal(t, "http://localhost:5000/fhir", projects[0].cfg.Fhir.Pseudonymizer.Url)
+
+	// overrides are merged
+	assert.Equal(t, "study-b", projects[1].name)
+	assert.Equal(t, 10, projects[1].cfg.App.Concurrency)
+	assert.Equal(t, "http://pseudonymizer-b:5000/fhir", projects[1].cfg.Fhir.Pseudonymizer.Url)
+	assert.Equal(t, "secret", projects[1].cfg.Fhir.Pseudonymizer.Auth.Basic.Password)
+	assert.Equal(t, 1000, projects[1].cfg.Fhir.Provider.MongoDb.BatchSize)
+
+	// the app config is unchanged
+	assert.Equal(t, 5, cfg.App.Concurrency)
+}
+
+func TestValidateCmdProjectFlags(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/projects.yaml"
+	defer func() { cfgFile = "" }()
+
+	initConfig()
+
+	// flags take precedence over the configured projects
+	projectNames = []string{"study-b", "study-c"}
+	projects, err := validateCmd()
+	assert.NoError(t, err)
+	assert.Equal(t, "study-b", projects[0].name)
+	assert.Equal(t, 10, projects[0].cfg.App.Concurrency)
+	assert.Equal(t, "study-c", projects[1].name)
+	assert.Equal(t, 5, projects[1].cfg.App.Concurrency)
+
+	projectNames = []string{"study-a", "study-a"}
+	_, err = validateCmd()
+	assert.EqualError(t, err, "duplicate project study-a")
+}
+
+func TestValidateCmdNoProject(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+	defer func() { cfgFile = "" }()
+
+	initConfig()
+	_, err := validateCmd()
+
+	assert.EqualError(t, err, "no project set, use the -p flag or the projects config")
+}
+
+func TestRunProjectsReport(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/projects.yaml"
+	defer func() { cfgFile = "" }()
+
+	initConfig()
+	statusFile := filepath.Join(t.TempDir(), "report.json")
+	cfg.App.StatusFile = statusFile
+	projects, _ := validateCmd()
+
+	// already cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := runProjects(ctx, projects)
+
+	assert.ErrorContains(t, err, "2 of 2 projects did not complete")
+
+	data, _ := os.ReadFile(statusFile)
+	var report fhir.Report
+	_ = json.Unmarshal(data, &report)
+	assert.Len(t, report.Projects, 2)
+	assert.Equal(t, "study-a", report.Projects[0].Project)
+	assert.Equal(t, "study-b", report.Projects[1].Project)
+	assert.NotEqual(t, fhir.StateCompleted, report.State)
+}
+
+func TestRunProjectsParallelNoProgressBar(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/projects.yaml"
+	defer func() { cfgFile = "" }()
+
+	initConfig()
+	cfg.App.StatusFile = ""
+	projects, _ := validateCmd()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, parallel := range []int{1, 2} {
+		cfg.App.ParallelProjects = parallel
+		for _, p := range projects {
+			p.cfg.App.Progress.Bar = true
+		}
+
+		_ = runProjects(ctx, projects)
+
+		for _, p := range projects {
+			assert.Equal(t, parallel == 1, p.cfg.App.Progress.Bar, "%s with %d parallel projects", p.name, parallel)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"pseudonymous/fhir"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+var reportFile string
+
+func NewReportCmd() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Show the status of the last run saved to the status file",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			file := reportFile
+			if file == "" {
+				file = cfg.App.StatusFile
+			}
+			if file == "" {
+				return usageError{errors.New("no status file set, use the --file flag or app.status-file")}
+			}
+
+			report, err := readReport(file)
+			if err != nil {
+				return err
+			}
+			return printReport(cmd.OutOrStdout(), report)
+		},
+	}
+
+	cmd.Flags().StringVarP(&reportFile, "file", "f", "", "status file (default is app.status-file)")
+
+	return cmd
+}
+
+// readReport reads the status of a single project or the combined report of
+// multiple projects
+func readReport(file string) (fhir.Report, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fhir.Report{}, fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	var report fhir.Report
+	if err = json.Unmarshal(data, &report); err != nil {
+		return fhir.Report{}, fmt.Errorf("invalid status file %s: %w", file, err)
+	}
+	if report.Projects != nil {
+		return report, nil
+	}
+
+	var status fhir.RunStatus
+	if err = json.Unmarshal(data, &status); err != nil {
+		return fhir.Report{}, fmt.Errorf("invalid status file %s: %w", file, err)
+	}
+	return fhir.Report{
+		State:    status.State,
+		Start:    status.Start,
+		End:      status.End,
+		Duration: status.Duration,
+		Projects: []fhir.RunStatus{status},
+	}, nil
+}
+
+func printReport(out io.Writer, report fhir.Report) error {
+	_, _ = fmt.Fprintf(out, "State: %s, started %s, duration %s\n\n",
+		report.State, report.Start.Format("2006-01-02 15:04:05"), report.Duration)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PROJECT\tSTATE\tDURATION\tCOUNT\tRETRIES\tERROR")
+	for _, p := range report.Projects {
+		retries := make(map[string]int, len(p.Retries))
+		for c, r := range p.Retries {
+			retries[c] = r.Retries
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Project, p.State, p.Duration, formatCounts(p.Count), formatCounts(retries), p.Error)
+	}
+	return w.Flush()
+}
+
+// formatCounts returns the counts per collection, sorted by collection
+func formatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"pseudonymous/fhir"
+	"testing"
+	"time"
+)
+
+func TestReportCmd(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+	defer func() { reportFile = "" }()
+	out := new(bytes.Buffer)
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+
+	file := filepath.Join(t.TempDir(), "status.json")
+	data, _ := json.Marshal(fhir.NewReport(time.Now(), []fhir.RunStatus{
+		{Project: "study-a", State: fhir.StateCompleted, Duration: "1m0s", Count: map[string]int{"Patient": 2, "Encounter": 1}},
+		{Project: "study-b", State: fhir.StateFailed, Duration: "0s", Error: "preflight check failed"},
+	}))
+	_ = os.WriteFile(file, data, 0600)
+
+	rootCmd.SetArgs([]string{"report", "-f", file})
+
+	err := rootCmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "State: failed")
+	assert.Regexp(t, `study-a\s+completed\s+1m0s\s+Encounter=1,Patient=2`, out.String())
+	assert.Regexp(t, `study-b\s+failed\s+0s\s+preflight check failed`, out.String())
+}
+
+func TestReadReportSingleProject(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "status.json")
+	_ = fhir.NewRunStatus("test", time.Now(), fhir.ProcessResult{}, nil).Save(file)
+
+	report, err := readReport(file)
+
+	assert.NoError(t, err)
+	assert.Equal(t, fhir.StateCompleted, report.State)
+	assert.Len(t, report.Projects, 1)
+	assert.Equal(t, "test", report.Projects[0].Project)
+}
+
+func TestReportCmd_NoFile(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+
+	rootCmd.SetArgs([]string{"report"})
+
+	err := rootCmd.Execute()
+
+	assert.EqualError(t, err, "no status file set, use the --file flag or app.status-file")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -1,65 +1,67 @@
 package cmd
 
 import (
-	"errors"
+	"context"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"log/slog"
 	"os"
+	"os/signal"
 	"pseudonymous/config"
-	"pseudonymous/fhir"
-	"regexp"
-	"strings"
+	"pseudonymous/tracing"
+	"syscall"
+	"time"
 )
 
 var (
-	projectName string
-	cfgFile     string
-	cfg         *config.AppConfig
-	rootCmd     = NewRootCmd()
+	projectNames []string
+	cfgFile      string
+	cfg          *config.AppConfig
+	rootCmd      = NewRootCmd()
 )
 
 func NewRootCmd() *cobra.Command {
 
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "pseudonymous",
 		Short: "Pseudonymization of FHIR resources via the FHIR Pseudonymizer service ",
-		RunE: func(_ *cobra.Command, _ []string) error {
-			if err := validateCmd(); err != nil {
-				slog.Error("Failed to validate command flags", "error", err.Error())
-				return err
-			}
-
-			config.ConfigureLogger(*cfg)
-			p, err := fhir.NewProcessor(cfg, projectName)
-			if err != nil {
-				return err
-			}
-			_, err = p.Run()
-			if err != nil {
-				slog.Error("Processor run exited", "error", err.Error())
-			}
-			return err
-		},
+		// alias of the run command
+		RunE: run,
 	}
+	cmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return usageError{err}
+	})
+
+	return cmd
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		slog.Error("Execution failed", "error", err.Error())
-		os.Exit(1)
+	// cancel on shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+
+	if err != nil {
+		code := exitCode(err)
+		slog.Error("Execution failed", "error", err.Error(), "exitCode", code)
+		os.Exit(code)
 	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	rootCmd.PersistentFlags().StringVarP(&projectName, "project", "p", "", "project name (required)")
-	if err := rootCmd.MarkPersistentFlagRequired("project"); err != nil {
-		slog.Error("Please provide a project name with the -p flag")
-		os.Exit(1)
-	}
+	rootCmd.PersistentFlags().StringArrayVarP(&projectNames, "project", "p", nil, "project name, may be repeated (default are the configured projects)")
 
 	rootCmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (default is ./app.yaml)")
+	addConfigFlags(rootCmd.PersistentFlags())
+
+	rootCmd.AddCommand(NewRunCmd())
+	rootCmd.AddCommand(NewCheckCmd())
+	rootCmd.AddCommand(NewConfigCmd())
+	rootCmd.AddCommand(NewGpasCmd())
+	rootCmd.AddCommand(NewVerifyCmd())
+	rootCmd.AddCommand(NewReportCmd())
+	rootCmd.AddCommand(NewExportCmd())
 }
 
 func initConfig() {
@@ -73,59 +75,69 @@ func initConfig() {
 		viper.SetConfigType("yaml")
 	}
 
-	viper.AutomaticEnv()
-	viper.SetEnvKeyReplacer(strings.NewReplacer(`.`, `_`, `-`, `_`))
-
-	parseMapEnvs()
+	if err := bindEnvs(); err != nil {
+		slog.Error("Error reading config from environment", "error", err.Error())
+		os.Exit(exitUsage)
+	}
+	if err := bindFlags(rootCmd.PersistentFlags()); err != nil {
+		slog.Error("Error reading config from flags", "error", err.Error())
+		os.Exit(exitUsage)
+	}
 
 	if err := viper.ReadInConfig(); err == nil {
 		slog.Info("Using config file", "file", viper.ConfigFileUsed())
 	} else {
 		slog.Error("Error reading config", "error", err.Error())
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
-	err := viper.Unmarshal(&cfg)
+	// decode into a fresh config, unset values must not be kept from before
+	cfg = new(config.AppConfig)
+	err := viper.Unmarshal(cfg, viper.DecodeHook(config.DecodeHook()))
 	if err != nil {
 		slog.Error("Error unmarshalling app config", "error", err.Error())
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	if err = cfg.ResolveSecrets(); err != nil {
+		slog.Error("Error resolving secrets of app config", "error", err.Error())
+		os.Exit(exitUsage)
 	}
 }
 
-func parseMapEnvs() {
-	// reverse (doesn't work for '-' though)
-	replacer := strings.NewReplacer(`_`, `.`)
-
-	configMap := make(map[string]map[string]string)
-	re := regexp.MustCompile(`(.*)\[[0-9]+]$`)
-	for _, e := range os.Environ() {
-		split := strings.Split(e, "=")
-		k := split[0]
-		v := strings.Split(split[1], ":")
-
-		result := re.FindStringSubmatch(k)
-
-		if result != nil {
-			key := replacer.Replace(result[1])
-
-			var val map[string]string
-			val, exists := configMap[key]
-			if !exists {
-				val = make(map[string]string)
-				configMap[key] = val
-			}
-			val[v[0]] = v[1]
-		}
+// bindEnvs binds the environment variables of all config keys, including keys
+// missing in the config file, and sets lists with entries given by index
+func bindEnvs() error {
+	env, err := config.FromEnv(os.Environ())
+	if err != nil {
+		return err
 	}
 
-	for k, v := range configMap {
-		viper.Set(k, v)
+	for key, name := range env.Keys {
+		if err = viper.BindEnv(key, name); err != nil {
+			return err
+		}
+	}
+	for key, list := range env.Lists {
+		viper.Set(key, list)
 	}
+	return nil
 }
 
-func validateCmd() error {
-	if projectName == "" {
-		return errors.New("project name is empty")
+// configureTracing sets up tracing and returns a function to flush remaining
+// spans on exit
+func configureTracing() (func(), error) {
+	shutdown, err := tracing.Configure(cfg.App.Tracing)
+	if err != nil {
+		slog.Error("Failed to configure tracing", "error", err.Error())
+		return nil, err
 	}
-	return nil
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			slog.Error("Failed to flush traces", "error", err.Error())
+		}
+	}, nil
 }
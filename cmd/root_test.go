@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"path"
+	"pseudonymous/config"
 	"runtime"
 	"testing"
 )
@@ -24,14 +26,14 @@ func TestInitConfigWithEnv(t *testing.T) {
 func TestInitConfigWithEnvMap(t *testing.T) {
 	setProjectDir()
 
-	expected := map[string]string{
-		"patient":   "PATIENT",
-		"encounter": "ENC",
+	expected := []config.Domain{
+		{Name: "patient", Prefix: "PATIENT"},
+		{Name: "encounter", Prefix: "ENC"},
+		{Name: "case", Prefix: "CASE"},
 	}
-	i := 0
-	for k, v := range expected {
-		t.Setenv(fmt.Sprintf("GPAS_DOMAINS_CONFIG[%d]", i), fmt.Sprintf("%s:%s", k, v))
-		i++
+	// set in reverse order
+	for i := len(expected) - 1; i >= 0; i-- {
+		t.Setenv(fmt.Sprintf("GPAS_DOMAINS_CONFIG[%d]", i), fmt.Sprintf("%s:%s", expected[i].Name, expected[i].Prefix))
 	}
 
 	initConfig()
@@ -39,6 +41,63 @@ func TestInitConfigWithEnvMap(t *testing.T) {
 	assert.Equal(t, expected, cfg.Gpas.Domains.Config)
 }
 
+func TestInitConfigDomainsFromFile(t *testing.T) {
+	setProjectDir()
+
+	cfgFile = "./testdata/domains.yaml"
+	defer func() { cfgFile = "" }()
+
+	initConfig()
+	fromFile := cfg.Gpas.Domains.Config
+
+	// same entries via env
+	setProjectDir()
+	t.Setenv("GPAS_DOMAINS_CONFIG[0]", "patient:PATIENT")
+	t.Setenv("GPAS_DOMAINS_CONFIG[1]", "encounter:ENC")
+	t.Setenv("GPAS_DOMAINS_CONFIG[2]", "case:CASE")
+
+	initConfig()
+
+	assert.Equal(t, []config.Domain{
+		{Name: "patient", Prefix: "PATIENT"},
+		{Name: "encounter", Prefix: "ENC"},
+		{Name: "case", Prefix: "CASE"},
+	}, fromFile)
+	assert.Equal(t, fromFile, cfg.Gpas.Domains.Config)
+}
+
+func TestInitConfigWithEnvNested(t *testing.T) {
+	setProjectDir()
+
+	cfgFile = "./testdata/test.yaml"
+	defer func() { cfgFile = "" }()
+	// not part of the config file
+	t.Setenv("GPAS_AUTH_OAUTH2_CLIENT_ID", "pseudonymous")
+	t.Setenv("GPAS_DOMAINS_CONFIG[0]_NAME", "lab")
+	t.Setenv("GPAS_DOMAINS_CONFIG[0]_PREFIX", "LAB:")
+	t.Setenv("GPAS_DOMAINS_CONFIG[0]_RESOURCE_TYPES", "Observation,DiagnosticReport")
+
+	initConfig()
+
+	assert.Equal(t, "pseudonymous", cfg.Gpas.Auth.OAuth2.ClientId)
+	assert.Equal(t, []config.Domain{
+		{Name: "lab", Prefix: "LAB:", ResourceTypes: []string{"Observation", "DiagnosticReport"}},
+	}, cfg.Gpas.Domains.Config)
+}
+
+func TestInitConfigSecrets(t *testing.T) {
+	setProjectDir()
+
+	cfgFile = "./testdata/secrets.yaml"
+	defer func() { cfgFile = "" }()
+	t.Setenv("TEST_LOG_LEVEL", "debug")
+
+	initConfig()
+
+	assert.Equal(t, "debug", cfg.App.LogLevel)
+	assert.Equal(t, "secret", cfg.Gpas.Auth.Basic.Password)
+}
+
 func TestInitConfigFromFlag(t *testing.T) {
 	setProjectDir()
 
@@ -55,6 +114,15 @@ func setProjectDir() {
 	_ = os.Chdir(dir)
 
 	viper.Reset()
+	// flags keep their values across executions
+	rootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			_ = v.Replace(nil)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
 }
 
 func TestExecuteCommand_InvalidFlag(t *testing.T) {
@@ -82,7 +150,7 @@ func TestExecuteCommand_EmptyProject(t *testing.T) {
 func TestNewRootCmd_Fails(t *testing.T) {
 	setProjectDir()
 	cfgFile = "./testdata/test.yaml"
-	projectName = "test"
+	projectNames = []string{"test"}
 
 	cmd := NewRootCmd()
 
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"log/slog"
+	"pseudonymous/config"
+	"pseudonymous/metrics"
+)
+
+func NewRunCmd() *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "run",
+		Short: "Pseudonymize the resources of the projects",
+		RunE:  run,
+	}
+}
+
+// run processes the projects. It's the root command as well.
+func run(cmd *cobra.Command, _ []string) error {
+	projects, err := validateCmd()
+	if err != nil {
+		slog.Error("Failed to validate command flags", "error", err.Error())
+		return err
+	}
+
+	config.ConfigureLogger(*cfg)
+	if srv := metrics.Serve(cfg.App.Metrics); srv != nil {
+		defer func() { _ = srv.Close() }()
+	}
+	shutdown, err := configureTracing()
+	if err != nil {
+		return err
+	}
+	defer shutdown()
+
+	return runProjects(cmd.Context(), projects)
+}
@@ -0,0 +1,18 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRunCmd_EmptyProject(t *testing.T) {
+	setProjectDir()
+	cfgFile = "./testdata/test.yaml"
+
+	rootCmd.SetArgs([]string{"run", "-p", ""})
+
+	err := rootCmd.Execute()
+
+	assert.EqualError(t, err, "project name is empty")
+	assert.Equal(t, exitUsage, exitCode(err))
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/spf13/cobra"
+	"log/slog"
+	"pseudonymous/config"
+	"pseudonymous/fhir"
+)
+
+func NewVerifyCmd() *cobra.Command {
+
+	return &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the gPAS domains required for the source resources exist",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			projects, err := validateCmd()
+			if err != nil {
+				slog.Error("Failed to validate command flags", "error", err.Error())
+				return err
+			}
+
+			config.ConfigureLogger(*cfg)
+			var errs []error
+			for _, p := range projects {
+				if err = verify(cmd, p); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}
+}
+
+func verify(cmd *cobra.Command, p project) error {
+	processor, err := fhir.NewProcessor(p.cfg, p.name)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = processor.Close() }()
+
+	return processor.VerifyDomains(cmd.Context())
+}
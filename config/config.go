@@ -11,17 +11,64 @@ type AppConfig struct {
 	App  App  `mapstructure:"app"`
 	Gpas Gpas `mapstructure:"gpas"`
 	Fhir Fhir `mapstructure:"fhir"`
+	// Projects are processed if no project is set on the command line
+	Projects []Project `mapstructure:"projects"`
+}
+
+// Project is a project to process. Its config overrides the app config and has
+// the structure of the config file.
+type Project struct {
+	Name   string                 `mapstructure:"name"`
+	Config map[string]interface{} `mapstructure:"config"`
 }
 
 type App struct {
-	LogLevel    string `mapstructure:"log-level"`
-	Concurrency int    `mapstructure:"concurrency"`
+	LogLevel         string   `mapstructure:"log-level"`
+	Concurrency      int      `mapstructure:"concurrency"`
+	WriteConcurrency int      `mapstructure:"write-concurrency"`
+	Buffers          Buffers  `mapstructure:"buffers"`
+	Metrics          Metrics  `mapstructure:"metrics"`
+	Progress         Progress `mapstructure:"progress"`
+	Tracing          Tracing  `mapstructure:"tracing"`
+	GracePeriod      int      `mapstructure:"grace-period"`
+	StatusFile       string   `mapstructure:"status-file"`
+	Preflight        bool     `mapstructure:"preflight"`
+	ParallelProjects int      `mapstructure:"parallel-projects"`
+}
+
+// Buffers are the sizes of the queues between the processing stages
+type Buffers struct {
+	Jobs    int `mapstructure:"jobs"`
+	Writes  int `mapstructure:"writes"`
+	Results int `mapstructure:"results"`
+}
+
+type Tracing struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Exporter    string  `mapstructure:"exporter"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	SampleRatio float64 `mapstructure:"sample-ratio"`
+}
+
+type Progress struct {
+	Interval int  `mapstructure:"interval"`
+	Bar      bool `mapstructure:"bar"`
+}
+
+type Metrics struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Address string `mapstructure:"address"`
+	Path    string `mapstructure:"path"`
 }
 
 type Gpas struct {
-	Url     string  `mapstructure:"url"`
-	Auth    *Auth   `mapstructure:"auth"`
-	Domains Domains `mapstructure:"domains"`
+	Url       string    `mapstructure:"url"`
+	PsnUrl    string    `mapstructure:"psn-url"`
+	Auth      *Auth     `mapstructure:"auth"`
+	Retry     Retry     `mapstructure:"retry"`
+	RateLimit RateLimit `mapstructure:"rate-limit"`
+	Tls       *Tls      `mapstructure:"tls"`
+	Domains   Domains   `mapstructure:"domains"`
 }
 
 type Fhir struct {
@@ -34,23 +81,81 @@ type Provider struct {
 }
 
 type MongoDb struct {
-	Connection string `mapstructure:"connection"`
-	BatchSize  int    `mapstructure:"batch-size"`
+	Connection      string `mapstructure:"connection"`
+	ConnectionFile  string `mapstructure:"connection-file"`
+	BatchSize       int    `mapstructure:"batch-size"`
+	ConnectTimeout  int    `mapstructure:"connect-timeout"`
+	CursorTimeout   int    `mapstructure:"cursor-timeout"`
+	NoCursorTimeout bool   `mapstructure:"no-cursor-timeout"`
+	Readers         int    `mapstructure:"readers"`
+	Partitions      int    `mapstructure:"partitions"`
+	Tls             *Tls   `mapstructure:"tls"`
 }
 
 type Pseudonymizer struct {
-	Url   string `mapstructure:"url"`
-	Retry Retry  `mapstructure:"retry"`
-	Auth  *Auth  `mapstructure:"auth"`
+	Url       string    `mapstructure:"url"`
+	Rules     string    `mapstructure:"rules"`
+	Retry     Retry     `mapstructure:"retry"`
+	RateLimit RateLimit `mapstructure:"rate-limit"`
+	Auth      *Auth     `mapstructure:"auth"`
+	Tls       *Tls      `mapstructure:"tls"`
+	Adaptive  Adaptive  `mapstructure:"adaptive"`
+	// CircuitBreaker is disabled without a threshold
+	CircuitBreaker CircuitBreaker `mapstructure:"circuit-breaker"`
+}
+
+// CircuitBreaker opens after the threshold of consecutive failed requests.
+// The probe interval and the timeout to give up are in seconds.
+type CircuitBreaker struct {
+	Threshold     int `mapstructure:"threshold"`
+	ProbeInterval int `mapstructure:"probe-interval"`
+	Timeout       int `mapstructure:"timeout"`
+}
+
+// RateLimit is a token bucket limit of requests per second with the given
+// burst size
+type RateLimit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// Adaptive configures the adaptive concurrency of pseudonymizer requests.
+// The target latency is in milliseconds.
+type Adaptive struct {
+	Enabled       bool `mapstructure:"enabled"`
+	Min           int  `mapstructure:"min"`
+	Max           int  `mapstructure:"max"`
+	TargetLatency int  `mapstructure:"target-latency"`
 }
 
 type Auth struct {
-	Basic *Basic `mapstructure:"basic"`
+	Basic  *Basic  `mapstructure:"basic"`
+	OAuth2 *OAuth2 `mapstructure:"oauth2"`
+}
+
+// OAuth2 configures the client credentials grant
+type OAuth2 struct {
+	TokenUrl         string   `mapstructure:"token-url"`
+	ClientId         string   `mapstructure:"client-id"`
+	ClientSecret     string   `mapstructure:"client-secret"`
+	ClientSecretFile string   `mapstructure:"client-secret-file"`
+	Scopes           []string `mapstructure:"scopes"`
 }
 
 type Basic struct {
-	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
+	Username     string `mapstructure:"username"`
+	UsernameFile string `mapstructure:"username-file"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password-file"`
+}
+
+type Tls struct {
+	CaFile             string `mapstructure:"ca-file"`
+	CertFile           string `mapstructure:"cert-file"`
+	KeyFile            string `mapstructure:"key-file"`
+	ServerName         string `mapstructure:"server-name"`
+	MinVersion         string `mapstructure:"min-version"`
+	InsecureSkipVerify bool   `mapstructure:"insecure-skip-verify"`
 }
 
 type Retry struct {
@@ -61,9 +166,19 @@ type Retry struct {
 }
 
 type Domains struct {
-	AutoCreate  bool              `mapstructure:"auto-create"`
-	UseExisting bool              `mapstructure:"use-existing"`
-	Config      map[string]string `mapstructure:"config"`
+	AutoCreate  bool     `mapstructure:"auto-create"`
+	UseExisting bool     `mapstructure:"use-existing"`
+	Verify      bool     `mapstructure:"verify"`
+	Config      []Domain `mapstructure:"config"`
+}
+
+type Domain struct {
+	Name          string   `mapstructure:"name"`
+	Prefix        string   `mapstructure:"prefix"`
+	Parents       []string `mapstructure:"parents"`
+	Label         string   `mapstructure:"label"`
+	Comment       string   `mapstructure:"comment"`
+	ResourceTypes []string `mapstructure:"resource-types"`
 }
 
 func ConfigureLogger(c AppConfig) {
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EnvSettings are the config settings provided by environment variables
+type EnvSettings struct {
+	// Keys maps config keys to the environment variables setting them
+	Keys map[string]string
+	// Lists are the lists with entries set by index
+	Lists map[string][]interface{}
+}
+
+// setting is a config key with the type of its value
+type setting struct {
+	key string
+	typ reflect.Type
+}
+
+var envListEntry = regexp.MustCompile(`^(.+)\[([0-9]+)](?:_(.+))?$`)
+
+// EnvKey returns the name of the environment variable of a config key, i.e.
+// the upper case key with dots and dashes replaced by underscores
+func EnvKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// Keys returns the keys of all config settings. Lists are single settings.
+func Keys() []string {
+	var keys []string
+	for _, s := range settings(reflect.TypeOf(AppConfig{}), "") {
+		keys = append(keys, s.key)
+	}
+	return keys
+}
+
+// FromEnv returns the config settings of the environment (as os.Environ).
+// Variables are named by EnvKey. Entries of lists are set by index, either as a
+// whole (GPAS_DOMAINS_CONFIG[0]=patient:PATIENT) or by their fields
+// (GPAS_DOMAINS_CONFIG[0]_RESOURCE_TYPES=Patient,Encounter). Lists set by index
+// replace the list of the config file.
+func FromEnv(environ []string) (EnvSettings, error) {
+	known := make(map[string]setting)
+	for _, s := range settings(reflect.TypeOf(AppConfig{}), "") {
+		known[EnvKey(s.key)] = s
+	}
+
+	env := EnvSettings{Keys: make(map[string]string), Lists: make(map[string][]interface{})}
+	entries := make(map[string]map[int]interface{})
+	for _, e := range environ {
+		name, value, _ := strings.Cut(e, "=")
+
+		if s, ok := known[name]; ok {
+			env.Keys[s.key] = name
+			continue
+		}
+
+		m := envListEntry.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		s, ok := known[m[1]]
+		if !ok || s.typ.Kind() != reflect.Slice {
+			continue
+		}
+		index, err := strconv.Atoi(m[2])
+		if err != nil {
+			return EnvSettings{}, fmt.Errorf("invalid index of environment variable %s: %w", name, err)
+		}
+
+		list, exists := entries[s.key]
+		if !exists {
+			list = make(map[int]interface{})
+			entries[s.key] = list
+		}
+		entry, err := listEntry(s.typ.Elem(), list[index], m[3], value)
+		if err != nil {
+			return EnvSettings{}, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+		list[index] = entry
+	}
+
+	// entries are ordered by index
+	for key, list := range entries {
+		indices := make([]int, 0, len(list))
+		for i := range list {
+			indices = append(indices, i)
+		}
+		slices.Sort(indices)
+
+		values := make([]interface{}, 0, len(list))
+		for _, i := range indices {
+			values = append(values, list[i])
+		}
+		env.Lists[key] = values
+	}
+
+	return env, nil
+}
+
+// listEntry sets the value of a list entry or, for lists of structs, one of
+// its fields
+func listEntry(typ reflect.Type, entry interface{}, field, value string) (interface{}, error) {
+	if field == "" {
+		if entry != nil {
+			return nil, fmt.Errorf("list entry is already set")
+		}
+		return value, nil
+	}
+
+	if typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("list entries have no fields")
+	}
+	fields, ok := entry.(map[string]interface{})
+	if !ok {
+		if entry != nil {
+			return nil, fmt.Errorf("list entry is already set")
+		}
+		fields = make(map[string]interface{})
+	}
+	for _, s := range settings(typ, "") {
+		if EnvKey(s.key) == field {
+			fields[s.key] = value
+			return fields, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown field %s of list entry", field)
+}
+
+// settings returns the keys of all values of a config struct. Nested structs
+// are flattened, lists are single values.
+func settings(t reflect.Type, prefix string) []setting {
+	var result []setting
+	for i := range t.NumField() {
+		f := t.Field(i)
+		key := f.Tag.Get("mapstructure")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		typ := f.Type
+		if typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() == reflect.Struct {
+			result = append(result, settings(typ, key)...)
+			continue
+		}
+		result = append(result, setting{key: key, typ: typ})
+	}
+	return result
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEnvKey(t *testing.T) {
+	assert.Equal(t, "APP_WRITE_CONCURRENCY", EnvKey("app.write-concurrency"))
+	assert.Equal(t, "FHIR_PSEUDONYMIZER_AUTH_OAUTH2_CLIENT_SECRET", EnvKey("fhir.pseudonymizer.auth.oauth2.client-secret"))
+}
+
+func TestFromEnv(t *testing.T) {
+	env, err := FromEnv([]string{
+		"APP_WRITE_CONCURRENCY=3",
+		// nested in a pointer struct
+		"GPAS_AUTH_OAUTH2_CLIENT_ID=pseudonymous",
+		// values may contain '=' and ':'
+		"FHIR_PSEUDONYMIZER_URL=http://localhost:5000/fhir?a=b",
+		"GPAS_DOMAINS_CONFIG[2]=case:CASE",
+		"GPAS_DOMAINS_CONFIG[0]=patient:PATIENT",
+		"GPAS_DOMAINS_CONFIG[1]_NAME=lab",
+		"GPAS_DOMAINS_CONFIG[1]_RESOURCE_TYPES=Observation,DiagnosticReport",
+		"FHIR_PSEUDONYMIZER_AUTH_OAUTH2_SCOPES[0]=openid",
+		"PATH=/usr/bin",
+		"OTHER[0]=test",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"app.write-concurrency":      "APP_WRITE_CONCURRENCY",
+		"gpas.auth.oauth2.client-id": "GPAS_AUTH_OAUTH2_CLIENT_ID",
+		"fhir.pseudonymizer.url":     "FHIR_PSEUDONYMIZER_URL",
+	}, env.Keys)
+	assert.Equal(t, map[string][]interface{}{
+		"gpas.domains.config": {
+			"patient:PATIENT",
+			map[string]interface{}{"name": "lab", "resource-types": "Observation,DiagnosticReport"},
+			"case:CASE",
+		},
+		"fhir.pseudonymizer.auth.oauth2.scopes": {"openid"},
+	}, env.Lists)
+}
+
+func TestFromEnvInvalid(t *testing.T) {
+	cases := []struct {
+		name     string
+		environ  []string
+		expected string
+	}{
+		{"unknown field", []string{"GPAS_DOMAINS_CONFIG[0]_FOO=bar"},
+			"environment variable GPAS_DOMAINS_CONFIG[0]_FOO: unknown field FOO of list entry"},
+		{"entry and field", []string{"GPAS_DOMAINS_CONFIG[0]=patient:PATIENT", "GPAS_DOMAINS_CONFIG[0]_NAME=patient"},
+			"environment variable GPAS_DOMAINS_CONFIG[0]_NAME: list entry is already set"},
+		{"field of scalar", []string{"FHIR_PSEUDONYMIZER_AUTH_OAUTH2_SCOPES[0]_NAME=openid"},
+			"environment variable FHIR_PSEUDONYMIZER_AUTH_OAUTH2_SCOPES[0]_NAME: list entries have no fields"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := FromEnv(c.environ)
+
+			assert.EqualError(t, err, c.expected)
+		})
+	}
+}
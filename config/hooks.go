@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-viper/mapstructure/v2"
+	"reflect"
+	"strings"
+)
+
+// DecodeHook returns the decode hooks used to unmarshal the app config.
+// In addition to viper's defaults, it supports references to environment
+// variables and the shorthand notation for gPAS domains.
+func DecodeHook() mapstructure.DecodeHookFunc {
+	return mapstructure.ComposeDecodeHookFunc(
+		EnvHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		DomainHookFunc(),
+		ProjectHookFunc(),
+	)
+}
+
+// DomainHookFunc converts the shorthand notation of a gPAS domain (name: prefix
+// or "name:prefix") to a domain definition
+func DomainHookFunc() mapstructure.DecodeHookFuncType {
+	return func(_ reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if t != reflect.TypeOf(Domain{}) {
+			return data, nil
+		}
+
+		if s, ok := data.(string); ok {
+			name, prefix, _ := strings.Cut(s, ":")
+			return map[string]interface{}{"name": name, "prefix": prefix}, nil
+		}
+
+		m, ok := toStringMap(data)
+		if !ok || len(m) != 1 {
+			return data, nil
+		}
+		for name, prefix := range m {
+			if name != "name" {
+				return map[string]interface{}{"name": name, "prefix": prefix}, nil
+			}
+		}
+
+		return data, nil
+	}
+}
+
+// ProjectHookFunc converts a project name to a project without overrides
+func ProjectHookFunc() mapstructure.DecodeHookFuncType {
+	return func(_ reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if t != reflect.TypeOf(Project{}) {
+			return data, nil
+		}
+
+		if name, ok := data.(string); ok {
+			return map[string]interface{}{"name": name}, nil
+		}
+		return data, nil
+	}
+}
+
+func toStringMap(data interface{}) (map[string]interface{}, bool) {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Map {
+		return nil, false
+	}
+
+	m := make(map[string]interface{}, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		m[fmt.Sprint(iter.Key().Interface())] = iter.Value().Interface()
+	}
+	return m, true
+}
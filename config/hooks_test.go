@@ -0,0 +1,71 @@
+package config
+
+import (
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDomainHookFunc(t *testing.T) {
+
+	cases := []struct {
+		name  string
+		input interface{}
+	}{
+		{
+			name: "shorthand list",
+			input: []interface{}{
+				map[string]interface{}{"patient": "PATIENT"},
+				map[string]interface{}{"encounter": "ENC"},
+			},
+		},
+		{
+			name:  "shorthand strings",
+			input: []interface{}{"patient:PATIENT", "encounter:ENC"},
+		},
+		{
+			name: "definitions",
+			input: []interface{}{
+				map[string]interface{}{"name": "patient", "prefix": "PATIENT"},
+				map[string]interface{}{"name": "encounter", "prefix": "ENC"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var domains []Domain
+			decoder, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+				DecodeHook: DecodeHook(),
+				Result:     &domains,
+			})
+
+			err := decoder.Decode(c.input)
+
+			assert.Nil(t, err)
+			assert.Equal(t, []Domain{
+				{Name: "patient", Prefix: "PATIENT"},
+				{Name: "encounter", Prefix: "ENC"},
+			}, domains)
+		})
+	}
+}
+
+func TestProjectHookFunc(t *testing.T) {
+	var projects []Project
+	decoder, _ := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: DecodeHook(),
+		Result:     &projects,
+	})
+
+	err := decoder.Decode([]interface{}{
+		"study-a",
+		map[string]interface{}{"name": "study-b", "config": map[string]interface{}{"app": map[string]interface{}{"concurrency": 10}}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Project{
+		{Name: "study-a"},
+		{Name: "study-b", Config: map[string]interface{}{"app": map[string]interface{}{"concurrency": 10}}},
+	}, projects)
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+	"time"
+)
+
+// tokenTimeout is the timeout of token requests
+const tokenTimeout = 30 * time.Second
+
+// TokenSource returns the source of access tokens obtained with the client
+// credentials grant. Tokens are cached and renewed shortly before they expire.
+// The token endpoint trusts the CAs of the given TLS config, if set. Its server
+// name and client certificate belong to the service and are not used.
+func (o OAuth2) TokenSource(tlsConfig *tls.Config) oauth2.TokenSource {
+	cfg := clientcredentials.Config{
+		ClientID:     o.ClientId,
+		ClientSecret: o.ClientSecret,
+		TokenURL:     o.TokenUrl,
+		Scopes:       o.Scopes,
+	}
+
+	client := &http.Client{Timeout: tokenTimeout}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig.Clone()
+		transport.TLSClientConfig.ServerName = ""
+		transport.TLSClientConfig.Certificates = nil
+		client.Transport = transport
+	}
+
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	return cfg.TokenSource(ctx)
+}
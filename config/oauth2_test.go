@@ -0,0 +1,67 @@
+package config
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTokenSource(t *testing.T) {
+	var requests atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_ = r.ParseForm()
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"secret-token","token_type":"Bearer","expires_in":300}`))
+	}))
+	defer s.Close()
+
+	tokens := OAuth2{TokenUrl: s.URL, ClientId: "test", ClientSecret: "secret", Scopes: []string{"read", "write"}}.TokenSource(nil)
+
+	token, err := tokens.Token()
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-token", token.AccessToken)
+
+	// cached until expired
+	_, _ = tokens.Token()
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestTokenSourceTls(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, _ := writeClientCertificate(t, dir)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no client certificate of the service
+		assert.Empty(t, r.TLS.PeerCertificates)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"secret-token","token_type":"Bearer","expires_in":300}`))
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	s.StartTLS()
+	defer s.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600)
+
+	// server name of the service, not of the token endpoint
+	tlsConfig, err := Tls{CaFile: caFile, CertFile: certFile, KeyFile: keyFile, ServerName: "gpas.example.org"}.ClientConfig()
+	assert.Nil(t, err)
+
+	tokens := OAuth2{TokenUrl: s.URL, ClientId: "test", ClientSecret: "secret"}.TokenSource(tlsConfig)
+	token, err := tokens.Token()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-token", token.AccessToken)
+	assert.Equal(t, "gpas.example.org", tlsConfig.ServerName)
+}
@@ -0,0 +1,13 @@
+package config
+
+import "golang.org/x/time/rate"
+
+// Limiter creates the token bucket limiting requests to the configured rate.
+// Requests are unlimited if no rate is set.
+func (r RateLimit) Limiter() *rate.Limiter {
+	if r.Rate <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	return rate.NewLimiter(rate.Limit(r.Rate), max(1, r.Burst))
+}
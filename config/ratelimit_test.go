@@ -0,0 +1,26 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+	"testing"
+)
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := RateLimit{}.Limiter()
+
+	assert.Equal(t, rate.Inf, l.Limit())
+}
+
+func TestLimiter(t *testing.T) {
+	l := RateLimit{Rate: 2.5, Burst: 5}.Limiter()
+
+	assert.Equal(t, rate.Limit(2.5), l.Limit())
+	assert.Equal(t, 5, l.Burst())
+}
+
+func TestLimiterDefaultBurst(t *testing.T) {
+	l := RateLimit{Rate: 10}.Limiter()
+
+	assert.Equal(t, 1, l.Burst())
+}
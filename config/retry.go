@@ -0,0 +1,24 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"net"
+	"net/url"
+)
+
+// TransportError reports whether err is a connection error of a request still
+// running. Requests failing before they are sent, e.g. in a rate limit wait,
+// have no response and are not retried, just like cancelled requests. It is
+// shared by the retry conditions of the pseudonymizer and gPAS clients.
+func TransportError(resp *resty.Response, err error) bool {
+	if resp == nil || resp.Request == nil || errors.Is(err, context.Canceled) ||
+		resp.Request.Context().Err() != nil {
+		return false
+	}
+
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
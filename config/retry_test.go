@@ -0,0 +1,26 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestTransportError(t *testing.T) {
+	req := resty.New().R()
+	connErr := &url.Error{Op: "Post", URL: "http://localhost", Err: errors.New("connection refused")}
+
+	assert.True(t, TransportError(&resty.Response{Request: req}, connErr))
+	assert.False(t, TransportError(&resty.Response{Request: req}, errors.New("invalid request")))
+	assert.False(t, TransportError(&resty.Response{Request: req}, context.Canceled))
+	// failed before the request was sent
+	assert.False(t, TransportError(nil, connErr))
+	assert.False(t, TransportError(&resty.Response{}, connErr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, TransportError(&resty.Response{Request: req.SetContext(ctx)}, connErr))
+}
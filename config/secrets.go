@@ -0,0 +1,166 @@
+package config
+
+import (
+	"fmt"
+	"github.com/go-viper/mapstructure/v2"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces secrets in logged or printed config
+const redacted = "xxxxx"
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)}`)
+
+// EnvHookFunc replaces references to environment variables (${NAME}) in
+// string values
+func EnvHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, _ reflect.Type, data interface{}) (interface{}, error) {
+		if f.Kind() != reflect.String {
+			return data, nil
+		}
+		return ExpandEnv(reflect.ValueOf(data).String())
+	}
+}
+
+// ExpandEnv replaces references to environment variables (${NAME}) in s. It
+// fails if a referenced variable is not set.
+func ExpandEnv(s string) (string, error) {
+	var err error
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s referenced in config is not set", name)
+		}
+		return value
+	})
+
+	return expanded, err
+}
+
+// ResolveSecrets reads the secrets configured as files (*-file). Values read
+// from files take precedence over the plain values.
+func (c *AppConfig) ResolveSecrets() error {
+	for _, auth := range []*Auth{c.Gpas.Auth, c.Fhir.Pseudonymizer.Auth} {
+		if auth == nil {
+			continue
+		}
+		if b := auth.Basic; b != nil {
+			if err := readSecret(b.UsernameFile, &b.Username); err != nil {
+				return err
+			}
+			if err := readSecret(b.PasswordFile, &b.Password); err != nil {
+				return err
+			}
+		}
+		if o := auth.OAuth2; o != nil {
+			if err := readSecret(o.ClientSecretFile, &o.ClientSecret); err != nil {
+				return err
+			}
+		}
+	}
+
+	mongo := &c.Fhir.Provider.MongoDb
+	return readSecret(mongo.ConnectionFile, &mongo.Connection)
+}
+
+func readSecret(file string, value *string) error {
+	if file == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read secret: %w", err)
+	}
+	*value = strings.TrimRight(string(data), "\r\n")
+
+	return nil
+}
+
+// Redacted returns a copy of the config with credentials and the passwords of
+// connection strings replaced
+func (c AppConfig) Redacted() AppConfig {
+	c.Gpas.Auth = c.Gpas.Auth.redacted()
+	c.Fhir.Pseudonymizer.Auth = c.Fhir.Pseudonymizer.Auth.redacted()
+	c.Fhir.Provider.MongoDb.Connection = RedactUrl(c.Fhir.Provider.MongoDb.Connection)
+
+	projects := make([]Project, 0, len(c.Projects))
+	for _, p := range c.Projects {
+		p.Config = redactMap(p.Config)
+		projects = append(projects, p)
+	}
+	if c.Projects != nil {
+		c.Projects = projects
+	}
+
+	return c
+}
+
+// redactMap returns a copy of config overrides with secrets replaced
+func redactMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+
+	r := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		switch s, isString := v.(string); {
+		case isString && (k == "password" || k == "client-secret"):
+			r[k] = redactSecret(s)
+		case isString && k == "connection":
+			r[k] = RedactUrl(s)
+		default:
+			if nested, ok := toStringMap(v); ok {
+				r[k] = redactMap(nested)
+			} else {
+				r[k] = v
+			}
+		}
+	}
+	return r
+}
+
+func (a *Auth) redacted() *Auth {
+	if a == nil {
+		return nil
+	}
+
+	r := *a
+	if a.Basic != nil {
+		b := *a.Basic
+		b.Password = redactSecret(b.Password)
+		r.Basic = &b
+	}
+	if a.OAuth2 != nil {
+		o := *a.OAuth2
+		o.ClientSecret = redactSecret(o.ClientSecret)
+		r.OAuth2 = &o
+	}
+	return &r
+}
+
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// RedactUrl replaces the password of a URL or connection string. Invalid URLs
+// are redacted entirely.
+func RedactUrl(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	return u.String()
+}
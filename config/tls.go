@@ -0,0 +1,57 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ClientConfig creates the TLS client configuration. The CA bundle, if set,
+// replaces the system's root certificates. The client certificate is
+// presented to servers requiring mutual TLS.
+func (t Tls) ClientConfig() (*tls.Config, error) {
+	minVersion := uint16(tls.VersionTLS12)
+	if t.MinVersion != "" {
+		v, ok := tlsVersions[t.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS min-version %s, use 1.2 or 1.3", t.MinVersion)
+		}
+		minVersion = v
+	}
+
+	cfg := &tls.Config{
+		MinVersion: minVersion,
+		ServerName: t.ServerName,
+		// #nosec G402 -- opt-in for test environments
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CaFile != "" {
+		pem, err := os.ReadFile(t.CaFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificates found in CA file %s", t.CaFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", t.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
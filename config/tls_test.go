@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTlsClientConfig(t *testing.T) {
+
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	// server certificate as CA bundle
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	_ = os.WriteFile(caFile, caPem, 0600)
+
+	tlsConfig, err := Tls{CaFile: caFile}.ClientConfig()
+	assert.Nil(t, err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(s.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTlsClientConfigInvalidCa(t *testing.T) {
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	_ = os.WriteFile(caFile, []byte("invalid"), 0600)
+
+	_, err := Tls{CaFile: caFile}.ClientConfig()
+
+	assert.ErrorContains(t, err, "no valid certificates found in CA file")
+}
+
+func TestTlsClientConfigMutual(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeClientCertificate(t, dir)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "pseudonymous", r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	s.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	s.StartTLS()
+	defer s.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	_ = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0600)
+
+	tlsConfig, err := Tls{CaFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}.ClientConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	resp, err := client.Get(s.URL)
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTlsClientConfigServerName(t *testing.T) {
+
+	tlsConfig, err := Tls{ServerName: "gpas.example.org"}.ClientConfig()
+
+	assert.Nil(t, err)
+	assert.Equal(t, "gpas.example.org", tlsConfig.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+}
+
+func TestTlsClientConfigInvalid(t *testing.T) {
+
+	_, err := Tls{MinVersion: "1.0"}.ClientConfig()
+	assert.EqualError(t, err, "unsupported TLS min-version 1.0, use 1.2 or 1.3")
+
+	_, err = Tls{CertFile: "missing.pem", KeyFile: "missing.key"}.ClientConfig()
+	assert.ErrorContains(t, err, "failed to load client certificate missing.pem")
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key
+// to dir
+func writeClientCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pseudonymous"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client.key")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return certFile, keyFile, cert
+}
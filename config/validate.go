@@ -0,0 +1,197 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// Validate checks required fields, URLs and value ranges of the config. All
+// problems found are returned joined.
+func (c AppConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, key string, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	// app
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.App.LogLevel)) == nil, "app.log-level", "is invalid: %q", c.App.LogLevel)
+	check(c.App.Concurrency >= 0, "app.concurrency", "must not be negative")
+	check(c.App.WriteConcurrency >= 0, "app.write-concurrency", "must not be negative")
+	check(c.App.Buffers.Jobs >= 0, "app.buffers.jobs", "must not be negative")
+	check(c.App.Buffers.Writes >= 0, "app.buffers.writes", "must not be negative")
+	check(c.App.Buffers.Results >= 0, "app.buffers.results", "must not be negative")
+	check(c.App.GracePeriod >= 0, "app.grace-period", "must not be negative")
+	check(c.App.ParallelProjects >= 0, "app.parallel-projects", "must not be negative")
+	check(c.App.Progress.Interval >= 0, "app.progress.interval", "must not be negative")
+	check(c.App.Tracing.SampleRatio >= 0 && c.App.Tracing.SampleRatio <= 1, "app.tracing.sample-ratio", "must be between 0 and 1")
+
+	// gPAS
+	gpasRequired := c.Gpas.Domains.AutoCreate || c.Gpas.Domains.Verify
+	errs = append(errs, validateUrl("gpas.url", c.Gpas.Url, gpasRequired))
+	errs = append(errs, validateUrl("gpas.psn-url", c.Gpas.PsnUrl, false))
+	errs = append(errs, validateRetry("gpas.retry", c.Gpas.Retry)...)
+	errs = append(errs, validateRateLimit("gpas.rate-limit", c.Gpas.RateLimit)...)
+	errs = append(errs, validateAuth("gpas.auth", c.Gpas.Auth)...)
+	errs = append(errs, validateTls("gpas.tls", c.Gpas.Tls))
+	errs = append(errs, validateDomains("gpas.domains.config", c.Gpas.Domains.Config)...)
+
+	// pseudonymizer
+	psn := c.Fhir.Pseudonymizer
+	errs = append(errs, validateUrl("fhir.pseudonymizer.url", psn.Url, true))
+	errs = append(errs, validateRetry("fhir.pseudonymizer.retry", psn.Retry)...)
+	errs = append(errs, validateRateLimit("fhir.pseudonymizer.rate-limit", psn.RateLimit)...)
+	errs = append(errs, validateAuth("fhir.pseudonymizer.auth", psn.Auth)...)
+	errs = append(errs, validateTls("fhir.pseudonymizer.tls", psn.Tls))
+	if psn.Adaptive.Enabled {
+		check(psn.Adaptive.Min > 0, "fhir.pseudonymizer.adaptive.min", "must be greater than 0")
+		check(psn.Adaptive.Max >= psn.Adaptive.Min, "fhir.pseudonymizer.adaptive.max", "must not be less than the min")
+		check(psn.Adaptive.TargetLatency >= 0, "fhir.pseudonymizer.adaptive.target-latency", "must not be negative")
+	}
+	check(psn.CircuitBreaker.Threshold >= 0, "fhir.pseudonymizer.circuit-breaker.threshold", "must not be negative")
+	check(psn.CircuitBreaker.ProbeInterval >= 0, "fhir.pseudonymizer.circuit-breaker.probe-interval", "must not be negative")
+	check(psn.CircuitBreaker.Timeout >= 0, "fhir.pseudonymizer.circuit-breaker.timeout", "must not be negative")
+
+	// MongoDB
+	mongo := c.Fhir.Provider.MongoDb
+	if mongo.Connection == "" {
+		errs = append(errs, errors.New("fhir.provider.mongodb.connection is required"))
+	} else {
+		check(strings.HasPrefix(mongo.Connection, "mongodb://") || strings.HasPrefix(mongo.Connection, "mongodb+srv://"),
+			"fhir.provider.mongodb.connection", "must start with mongodb:// or mongodb+srv://")
+	}
+	check(mongo.BatchSize > 0, "fhir.provider.mongodb.batch-size", "must be greater than 0")
+	check(mongo.ConnectTimeout >= 0, "fhir.provider.mongodb.connect-timeout", "must not be negative")
+	check(mongo.CursorTimeout >= 0, "fhir.provider.mongodb.cursor-timeout", "must not be negative")
+	check(mongo.Readers >= 0, "fhir.provider.mongodb.readers", "must not be negative")
+	check(mongo.Partitions >= 0, "fhir.provider.mongodb.partitions", "must not be negative")
+	errs = append(errs, validateTls("fhir.provider.mongodb.tls", mongo.Tls))
+
+	// projects
+	projects := make(map[string]bool, len(c.Projects))
+	for i, p := range c.Projects {
+		check(p.Name != "", fmt.Sprintf("projects[%d].name", i), "is required")
+		check(p.Name == "" || !projects[p.Name], fmt.Sprintf("projects[%d]:", i), "duplicate project %s", p.Name)
+		projects[p.Name] = true
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateUrl checks for an absolute http(s) URL
+func validateUrl(key, value string, required bool) error {
+	if value == "" {
+		if required {
+			return fmt.Errorf("%s is required", key)
+		}
+		return nil
+	}
+
+	u, err := url.ParseRequestURI(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s is not a valid http(s) URL: %q", key, RedactUrl(value))
+	}
+	return nil
+}
+
+func validateRetry(key string, r Retry) []error {
+	var errs []error
+	if r.Count < 0 {
+		errs = append(errs, fmt.Errorf("%s.count must not be negative", key))
+	}
+	if r.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("%s.timeout must not be negative", key))
+	}
+	if r.Wait < 0 {
+		errs = append(errs, fmt.Errorf("%s.wait must not be negative", key))
+	}
+	if r.MaxWait < r.Wait {
+		errs = append(errs, fmt.Errorf("%s.max-wait must not be less than the wait", key))
+	}
+	return errs
+}
+
+func validateRateLimit(key string, r RateLimit) []error {
+	var errs []error
+	if r.Rate < 0 {
+		errs = append(errs, fmt.Errorf("%s.rate must not be negative", key))
+	}
+	if r.Burst < 0 {
+		errs = append(errs, fmt.Errorf("%s.burst must not be negative", key))
+	}
+	return errs
+}
+
+func validateAuth(key string, a *Auth) []error {
+	if a == nil || a.OAuth2 == nil {
+		return nil
+	}
+
+	var errs []error
+	errs = append(errs, validateUrl(key+".oauth2.token-url", a.OAuth2.TokenUrl, true))
+	if a.OAuth2.ClientId == "" {
+		errs = append(errs, fmt.Errorf("%s.oauth2.client-id is required", key))
+	}
+	return errs
+}
+
+// validateDomains checks names and parent relations of the domain definitions
+func validateDomains(key string, domains []Domain) []error {
+	var errs []error
+	parents := make(map[string][]string, len(domains))
+	for i, d := range domains {
+		if d.Name == "" {
+			errs = append(errs, fmt.Errorf("%s[%d].name is required", key, i))
+			continue
+		}
+		if _, exists := parents[d.Name]; exists {
+			errs = append(errs, fmt.Errorf("%s[%d]: duplicate gPAS domain %s", key, i, d.Name))
+		}
+		parents[d.Name] = d.Parents
+	}
+	for i, d := range domains {
+		for _, p := range d.Parents {
+			if _, exists := parents[p]; !exists {
+				errs = append(errs, fmt.Errorf("%s[%d]: unknown parent domain %s of gPAS domain %s", key, i, p, d.Name))
+			}
+		}
+	}
+
+	// a domain must not be its own ancestor
+	var reaches func(from, to string, seen map[string]bool) bool
+	reaches = func(from, to string, seen map[string]bool) bool {
+		for _, p := range parents[from] {
+			if p == to {
+				return true
+			}
+			if !seen[p] {
+				seen[p] = true
+				if reaches(p, to, seen) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for i, d := range domains {
+		if d.Name != "" && reaches(d.Name, d.Name, map[string]bool{}) {
+			errs = append(errs, fmt.Errorf("%s[%d]: cyclic gPAS domain hierarchy at domain %s", key, i, d.Name))
+		}
+	}
+	return errs
+}
+
+func validateTls(key string, t *Tls) error {
+	if t == nil {
+		return nil
+	}
+	if _, err := t.ClientConfig(); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func validConfig() AppConfig {
+	return AppConfig{
+		App: App{LogLevel: "info", Concurrency: 5},
+		Gpas: Gpas{
+			Url:     "http://localhost:18080/gpas/DomainService?wsdl",
+			Retry:   Retry{Count: 10, Timeout: 10, Wait: 5, MaxWait: 20},
+			Domains: Domains{AutoCreate: true, Config: []Domain{{Name: "patient"}, {Name: "lab", Parents: []string{"patient"}}}},
+		},
+		Fhir: Fhir{
+			Pseudonymizer: Pseudonymizer{Url: "http://localhost:5000/fhir"},
+			Provider:      Provider{MongoDb: MongoDb{Connection: "mongodb://localhost", BatchSize: 5000}},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, validConfig().Validate())
+}
+
+func TestValidateInvalid(t *testing.T) {
+	c := validConfig()
+	c.App.LogLevel = "verbose"
+	c.App.Concurrency = -1
+	c.Gpas.Url = "localhost:18080"
+	c.Gpas.Retry.MaxWait = 1
+	c.Fhir.Pseudonymizer.Url = ""
+	c.Fhir.Provider.MongoDb.BatchSize = 0
+
+	err := c.Validate()
+
+	assert.EqualError(t, err, `app.log-level is invalid: "verbose"
+app.concurrency must not be negative
+gpas.url is not a valid http(s) URL: "localhost:18080"
+gpas.retry.max-wait must not be less than the wait
+fhir.pseudonymizer.url is required
+fhir.provider.mongodb.batch-size must be greater than 0`)
+}
+
+func TestValidateGpasUrlOptional(t *testing.T) {
+	c := validConfig()
+	c.Gpas.Url = ""
+	c.Gpas.Domains.AutoCreate = false
+
+	assert.NoError(t, c.Validate())
+}
+
+func TestValidateOAuth2(t *testing.T) {
+	c := validConfig()
+	c.Fhir.Pseudonymizer.Auth = &Auth{OAuth2: &OAuth2{}}
+
+	err := c.Validate()
+
+	assert.EqualError(t, err, `fhir.pseudonymizer.auth.oauth2.token-url is required
+fhir.pseudonymizer.auth.oauth2.client-id is required`)
+}
+
+func TestValidateDomains(t *testing.T) {
+	cases := []struct {
+		name     string
+		domains  []Domain
+		expected string
+	}{
+		{"empty name", []Domain{{Prefix: "PAT"}}, "gpas.domains.config[0].name is required"},
+		{"duplicate", []Domain{{Name: "patient"}, {Name: "patient"}}, "gpas.domains.config[1]: duplicate gPAS domain patient"},
+		{"unknown parent", []Domain{{Name: "lab", Parents: []string{"patient"}}}, "gpas.domains.config[0]: unknown parent domain patient of gPAS domain lab"},
+		{"cycle", []Domain{{Name: "a", Parents: []string{"b"}}, {Name: "b", Parents: []string{"a"}}},
+			"gpas.domains.config[0]: cyclic gPAS domain hierarchy at domain a\ngpas.domains.config[1]: cyclic gPAS domain hierarchy at domain b"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.Gpas.Domains.Config = c.domains
+
+			assert.EqualError(t, cfg.Validate(), c.expected)
+		})
+	}
+}
+
+func TestValidateMongoConnection(t *testing.T) {
+	c := validConfig()
+	c.Fhir.Provider.MongoDb.Connection = "localhost:27017"
+
+	assert.EqualError(t, c.Validate(), "fhir.provider.mongodb.connection must start with mongodb:// or mongodb+srv://")
+}
+
+func TestValidateProjects(t *testing.T) {
+	c := validConfig()
+	c.Projects = []Project{{Name: "study-a"}, {}, {Name: "study-a"}}
+
+	assert.EqualError(t, c.Validate(), `projects[1].name is required
+projects[2]: duplicate project study-a`)
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+)
+
+// MarshalYAML encodes the config with the keys of the config file, in the
+// order of the fields. Secrets are included, use Redacted to mask them.
+func (c AppConfig) MarshalYAML() (interface{}, error) {
+	return toNode(reflect.ValueOf(c))
+}
+
+func toNode(v reflect.Value) (*yaml.Node, error) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+		}
+		return toNode(v.Elem())
+	case reflect.Struct:
+		n := &yaml.Node{Kind: yaml.MappingNode}
+		for i := range v.NumField() {
+			value, err := toNode(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			key := &yaml.Node{Kind: yaml.ScalarNode, Value: v.Type().Field(i).Tag.Get("mapstructure")}
+			n.Content = append(n.Content, key, value)
+		}
+		return n, nil
+	case reflect.Slice:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for i := range v.Len() {
+			value, err := toNode(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			if value.Kind != yaml.ScalarNode {
+				n.Style = 0
+			}
+			n.Content = append(n.Content, value)
+		}
+		return n, nil
+	default:
+		n := &yaml.Node{}
+		return n, n.Encode(v.Interface())
+	}
+}
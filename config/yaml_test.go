@@ -0,0 +1,31 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"testing"
+)
+
+func TestMarshalYAML(t *testing.T) {
+	c := AppConfig{
+		Gpas: Gpas{
+			Auth:    &Auth{Basic: &Basic{Username: "test", Password: "secret"}},
+			Domains: Domains{Config: []Domain{{Name: "patient", ResourceTypes: []string{"Patient"}}}},
+		},
+	}
+
+	out, err := yaml.Marshal(c.Redacted())
+	assert.NoError(t, err)
+
+	var m map[string]interface{}
+	_ = yaml.Unmarshal(out, &m)
+	gpas := m["gpas"].(map[string]interface{})
+	basic := gpas["auth"].(map[string]interface{})["basic"].(map[string]interface{})
+	assert.Equal(t, "test", basic["username"])
+	assert.Equal(t, "xxxxx", basic["password"])
+	assert.Nil(t, gpas["tls"])
+
+	domains := gpas["domains"].(map[string]interface{})["config"].([]interface{})
+	assert.Equal(t, []interface{}{"Patient"}, domains[0].(map[string]interface{})["resource-types"])
+	assert.Contains(t, string(out), "resource-types: [Patient]")
+}
@@ -0,0 +1,105 @@
+package fhir
+
+import (
+	"context"
+	"math"
+	"pseudonymous/config"
+	"pseudonymous/metrics"
+	"sync"
+	"time"
+)
+
+// backoffFactor is the factor the limit is reduced by on overload
+const backoffFactor = 0.5
+
+// AdaptiveLimit limits the number of concurrent requests. The limit is
+// adjusted AIMD-style within its bounds: it's increased by one after a limit's
+// worth of successful requests and halved on overload, i.e. failed or retried
+// requests and requests slower than the target latency.
+type AdaptiveLimit struct {
+	mu            sync.Mutex
+	limit         float64
+	min           float64
+	max           float64
+	targetLatency time.Duration
+	inFlight      int
+	lastDecrease  time.Time
+	// closed and replaced whenever a slot may have become available
+	changed chan struct{}
+}
+
+func NewAdaptiveLimit(cfg config.Adaptive) *AdaptiveLimit {
+	minLimit := max(1, cfg.Min)
+	maxLimit := max(minLimit, cfg.Max)
+
+	metrics.PseudonymizerConcurrencyLimit.Set(float64(minLimit))
+	return &AdaptiveLimit{
+		limit:         float64(minLimit),
+		min:           float64(minLimit),
+		max:           float64(maxLimit),
+		targetLatency: time.Duration(cfg.TargetLatency) * time.Millisecond,
+		changed:       make(chan struct{}),
+	}
+}
+
+// Limit returns the current number of allowed concurrent requests
+func (l *AdaptiveLimit) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// Acquire waits for a free slot until ctx is done
+func (l *AdaptiveLimit) Acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release frees a slot and adjusts the limit by the request's outcome
+func (l *AdaptiveLimit) Release(latency time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if failed || (l.targetLatency > 0 && latency > l.targetLatency) {
+		// requests in flight at the time of a decrease are likely to be
+		// overloaded as well, so decrease at most once per request duration
+		if time.Since(l.lastDecrease) > latency {
+			l.limit = max(l.min, l.limit*backoffFactor)
+			l.lastDecrease = time.Now()
+		}
+	} else {
+		l.limit = min(l.max, l.limit+1/l.limit)
+	}
+	metrics.PseudonymizerConcurrencyLimit.Set(math.Floor(l.limit))
+
+	l.release()
+}
+
+// Drop frees a slot without adjusting the limit, e.g. for cancelled requests
+func (l *AdaptiveLimit) Drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.release()
+}
+
+func (l *AdaptiveLimit) release() {
+	l.inFlight--
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
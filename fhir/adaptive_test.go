@@ -0,0 +1,67 @@
+package fhir
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"pseudonymous/config"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimitIncrease(t *testing.T) {
+	l := NewAdaptiveLimit(config.Adaptive{Min: 2, Max: 3})
+
+	// additive increase: one per limit's worth of requests, capped at max
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, l.Acquire(context.Background()))
+		l.Release(time.Millisecond, false)
+	}
+
+	assert.Equal(t, 3, l.Limit())
+}
+
+func TestAdaptiveLimitDecrease(t *testing.T) {
+	l := NewAdaptiveLimit(config.Adaptive{Min: 2, Max: 16, TargetLatency: 100})
+	l.limit = 16
+
+	_ = l.Acquire(context.Background())
+	l.Release(time.Millisecond, true)
+	assert.Equal(t, 8, l.Limit())
+
+	// at most one decrease per request duration
+	_ = l.Acquire(context.Background())
+	l.Release(time.Hour, true)
+	assert.Equal(t, 8, l.Limit())
+
+	// too slow
+	l.lastDecrease = time.Time{}
+	_ = l.Acquire(context.Background())
+	l.Release(200*time.Millisecond, false)
+	assert.Equal(t, 4, l.Limit())
+
+	// bounded by min
+	l.lastDecrease = time.Time{}
+	_ = l.Acquire(context.Background())
+	l.Release(time.Millisecond, true)
+	l.lastDecrease = time.Time{}
+	_ = l.Acquire(context.Background())
+	l.Release(time.Millisecond, true)
+	assert.Equal(t, 2, l.Limit())
+}
+
+func TestAdaptiveLimitAcquire(t *testing.T) {
+	l := NewAdaptiveLimit(config.Adaptive{Min: 1, Max: 1})
+	assert.NoError(t, l.Acquire(context.Background()))
+
+	// no free slot
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, l.Acquire(ctx), context.DeadlineExceeded)
+
+	// released slot
+	acquired := make(chan error)
+	go func() { acquired <- l.Acquire(context.Background()) }()
+	l.Drop()
+
+	assert.NoError(t, <-acquired)
+}
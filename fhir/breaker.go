@@ -0,0 +1,142 @@
+package fhir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"pseudonymous/config"
+	"sync"
+	"time"
+)
+
+// defaultProbeInterval is the interval for probing an unavailable
+// pseudonymizer, if not configured
+const defaultProbeInterval = 10 * time.Second
+
+var ErrPseudonymizerUnavailable = errors.New("FHIR pseudonymizer unavailable")
+
+// CircuitBreaker stops requests to the pseudonymizer after consecutive
+// failures. While open, a single request is let through every probe interval
+// and all others wait until it succeeds. If the pseudonymizer stays
+// unavailable beyond the timeout, requests fail with
+// ErrPseudonymizerUnavailable.
+type CircuitBreaker struct {
+	mu            sync.Mutex
+	threshold     int
+	probeInterval time.Duration
+	timeout       time.Duration
+	failures      int
+	open          bool
+	probing       bool
+	openedAt      time.Time
+	nextProbe     time.Time
+	// closed and replaced on every state change
+	changed chan struct{}
+}
+
+func NewCircuitBreaker(cfg config.CircuitBreaker) *CircuitBreaker {
+	probeInterval := time.Duration(cfg.ProbeInterval) * time.Second
+	if probeInterval <= 0 {
+		probeInterval = defaultProbeInterval
+	}
+
+	return &CircuitBreaker{
+		threshold:     max(1, cfg.Threshold),
+		probeInterval: probeInterval,
+		timeout:       time.Duration(cfg.Timeout) * time.Second,
+		changed:       make(chan struct{}),
+	}
+}
+
+// Allow waits until a request may be sent or ctx is done. probe is true if the
+// request is the single probe of the open breaker.
+func (b *CircuitBreaker) Allow(ctx context.Context) (probe bool, err error) {
+	for {
+		b.mu.Lock()
+		if !b.open {
+			b.mu.Unlock()
+			return false, nil
+		}
+
+		now := time.Now()
+		if b.timeout > 0 && now.Sub(b.openedAt) > b.timeout {
+			b.mu.Unlock()
+			return false, fmt.Errorf("%w for more than %s", ErrPseudonymizerUnavailable, b.timeout)
+		}
+		if !b.probing && !now.Before(b.nextProbe) {
+			b.probing = true
+			b.mu.Unlock()
+			return true, nil
+		}
+
+		wait := b.probeInterval
+		if !b.probing {
+			wait = b.nextProbe.Sub(now)
+		}
+		if b.timeout > 0 {
+			wait = min(wait, b.openedAt.Add(b.timeout).Sub(now)+time.Millisecond)
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// Release gives up an allowed request without a result, e.g. if it was
+// cancelled. A released probe lets the next request probe.
+func (b *CircuitBreaker) Release(probe bool) {
+	if !probe {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.notify()
+
+	b.probing = false
+}
+
+// Record updates the state with the result of a request
+func (b *CircuitBreaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	defer b.notify()
+
+	if success {
+		if b.open {
+			slog.Info("FHIR pseudonymizer available again, resuming", "unavailable", time.Since(b.openedAt).Round(time.Second))
+		}
+		b.failures = 0
+		b.open = false
+		b.probing = false
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.open:
+		b.probing = false
+		b.nextProbe = time.Now().Add(b.probeInterval)
+	case b.failures >= b.threshold:
+		slog.Warn("FHIR pseudonymizer unavailable, pausing until it recovers",
+			"failures", b.failures, "probeInterval", b.probeInterval, "timeout", b.timeout)
+		b.open = true
+		b.openedAt = time.Now()
+		b.nextProbe = b.openedAt.Add(b.probeInterval)
+	}
+}
+
+func (b *CircuitBreaker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
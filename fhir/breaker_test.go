@@ -0,0 +1,81 @@
+package fhir
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"pseudonymous/config"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	b := NewCircuitBreaker(config.CircuitBreaker{Threshold: 2, ProbeInterval: 60})
+
+	b.Record(false)
+	_, err := b.Allow(context.Background())
+	assert.NoError(t, err)
+
+	b.Record(false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = b.Allow(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	b := NewCircuitBreaker(config.CircuitBreaker{Threshold: 1})
+	b.probeInterval = 10 * time.Millisecond
+	b.Record(false)
+
+	// a single probe after the interval
+	probe, err := b.Allow(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, probe)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = b.Allow(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the others continue on success of the probe
+	allowed := make(chan error)
+	go func() {
+		_, err := b.Allow(context.Background())
+		allowed <- err
+	}()
+	b.Record(true)
+
+	assert.NoError(t, <-allowed)
+	probe, err = b.Allow(context.Background())
+	assert.NoError(t, err)
+	assert.False(t, probe)
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	b := NewCircuitBreaker(config.CircuitBreaker{Threshold: 1})
+	b.probeInterval = 10 * time.Millisecond
+	b.Record(false)
+
+	probe, err := b.Allow(context.Background())
+	assert.NoError(t, err)
+
+	// the next request probes once the probe is released
+	allowed := make(chan bool)
+	go func() {
+		probe, _ := b.Allow(context.Background())
+		allowed <- probe
+	}()
+	b.Release(probe)
+
+	assert.True(t, <-allowed)
+	assert.True(t, b.open)
+}
+
+func TestCircuitBreakerTimeout(t *testing.T) {
+	b := NewCircuitBreaker(config.CircuitBreaker{Threshold: 1, ProbeInterval: 60})
+	b.timeout = 20 * time.Millisecond
+	b.Record(false)
+
+	_, err := b.Allow(context.Background())
+
+	assert.ErrorIs(t, err, ErrPseudonymizerUnavailable)
+}
@@ -2,44 +2,103 @@ package fhir
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-resty/resty/v2"
 	models "github.com/samply/golang-fhir-models/fhir-models/fhir"
 	"log/slog"
+	"net/http"
 	"pseudonymous/config"
+	"pseudonymous/metrics"
+	"pseudonymous/tracing"
 	"time"
 )
 
 type PsnClient struct {
 	rest   *resty.Client
 	config config.Pseudonymizer
+	// limit is nil unless adaptive concurrency is enabled
+	limit *AdaptiveLimit
+	// breaker is nil unless the circuit breaker is enabled
+	breaker *CircuitBreaker
 }
 
+// NewClient creates the pseudonymizer client. It returns nil if the TLS config
+// is invalid.
 func NewClient(cfg config.Pseudonymizer) *PsnClient {
+	limiter := cfg.RateLimit.Limiter()
 	pseudonymizer := resty.New().
 		SetLogger(config.DefaultLogger()).
 		SetRetryCount(cfg.Retry.Count).
 		SetTimeout(time.Duration(cfg.Retry.Timeout) * time.Second).
 		SetRetryWaitTime(time.Duration(cfg.Retry.Wait) * time.Second).
-		SetRetryMaxWaitTime(time.Duration(cfg.Retry.MaxWait) * time.Second)
+		SetRetryMaxWaitTime(time.Duration(cfg.Retry.MaxWait) * time.Second).
+		AddRetryCondition(retryable).
+		SetRetryAfter(retryAfter).
+		AddRetryHook(func(_ *resty.Response, _ error) {
+			metrics.RequestRetries.WithLabelValues("pseudonymizer").Inc()
+		}).
+		OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+			// applies to retries as well
+			return limiter.Wait(r.Context())
+		}).
+		OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+			tracing.Inject(r.Context(), r.Header)
+			return nil
+		})
+
+	var tlsConfig *tls.Config
+	if cfg.Tls != nil {
+		var err error
+		tlsConfig, err = cfg.Tls.ClientConfig()
+		if err != nil {
+			slog.Error("Failed to configure TLS for the FHIR pseudonymizer client", "error", err.Error())
+			return nil
+		}
+		pseudonymizer = pseudonymizer.SetTLSClientConfig(tlsConfig)
+	}
 
 	if cfg.Auth != nil {
 		if cfg.Auth.Basic != nil {
 			pseudonymizer = pseudonymizer.SetBasicAuth(cfg.Auth.Basic.Username, cfg.Auth.Basic.Password)
 		}
+		if cfg.Auth.OAuth2 != nil {
+			tokens := cfg.Auth.OAuth2.TokenSource(tlsConfig)
+			pseudonymizer = pseudonymizer.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+				token, err := tokens.Token()
+				if err != nil {
+					return fmt.Errorf("failed to get access token for the FHIR pseudonymizer: %w", err)
+				}
+				r.SetAuthScheme(token.Type()).SetAuthToken(token.AccessToken)
+				return nil
+			})
+		}
+	}
+
+	client := &PsnClient{rest: pseudonymizer, config: cfg}
+	if cfg.Adaptive.Enabled {
+		client.limit = NewAdaptiveLimit(cfg.Adaptive)
+	}
+	if cfg.CircuitBreaker.Threshold > 0 {
+		client.breaker = NewCircuitBreaker(cfg.CircuitBreaker)
 	}
 
-	return &PsnClient{rest: pseudonymizer, config: cfg}
+	return client
 }
 
-func (c *PsnClient) Send(fhir []byte, domain string) ([]byte, error) {
+// Send requests the pseudonymized resource. The number of retries of the
+// request is returned in any case.
+func (c *PsnClient) Send(ctx context.Context, fhir []byte, domain string) (psn []byte, retries int, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "pseudonymize")
+	defer func() { tracing.End(span, err) }()
 
 	resource := json.RawMessage{}
-	err := resource.UnmarshalJSON(fhir)
+	err = resource.UnmarshalJSON(fhir)
 	if err != nil {
 		slog.Error("Failed to unmarshal FHIR JSON payload", "error", err)
-		return nil, err
+		return nil, 0, err
 	}
 
 	params := models.Parameters{
@@ -57,13 +116,47 @@ func (c *PsnClient) Send(fhir []byte, domain string) ([]byte, error) {
 		},
 	}
 
-	resp, err := c.rest.R().
+	var probe bool
+	if c.breaker != nil {
+		if probe, err = c.breaker.Allow(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+	if c.limit != nil {
+		if err = c.limit.Acquire(ctx); err != nil {
+			if c.breaker != nil {
+				c.breaker.Release(probe)
+			}
+			return nil, 0, err
+		}
+	}
+
+	start := time.Now()
+	req := c.rest.R()
+	resp, err := req.
+		SetContext(ctx).
 		SetBody(params).
 		SetHeader("Content-Type", "application/fhir+json").
 		Post(c.config.Url + "/$de-identify")
+	// no response if the request failed before it was sent
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode()
+	}
+	metrics.Since(metrics.PseudonymizerRequestDuration, metrics.Code(status, err), start)
+	retries = max(0, req.Attempt-1)
+	c.release(ctx, resp, err, retries, time.Since(start))
+	if c.breaker != nil {
+		// cancelled requests tell nothing about the pseudonymizer
+		if ctx.Err() != nil {
+			c.breaker.Release(probe)
+		} else {
+			c.breaker.Record(err == nil && status < http.StatusInternalServerError && status != http.StatusTooManyRequests)
+		}
+	}
 	if err != nil {
 		slog.Error("Failed to send request to the FHIR pseudonymizer", "error", err)
-		return nil, err
+		return nil, retries, err
 	}
 
 	// http response status
@@ -71,9 +164,43 @@ func (c *PsnClient) Send(fhir []byte, domain string) ([]byte, error) {
 
 	if success {
 		slog.Log(context.Background(), slog.LevelDebug, "FHIR pseudonymizer response", "status", resp.Status(), "body", string(resp.Body()))
-		return resp.Body(), nil
+		return resp.Body(), retries, nil
 	}
 	slog.Log(context.Background(), slog.LevelError, "FHIR pseudonymizer response", "status", resp.Status(), "body", string(resp.Body()))
-	return nil, errors.New("FHIR pseudonymizer request returned no success")
+	return nil, retries, errors.New("FHIR pseudonymizer request returned no success")
+
+}
+
+// Check requests the capability statement of the pseudonymizer, without
+// retries
+func (c *PsnClient) Check(ctx context.Context) error {
+	resp, err := c.rest.Clone().SetRetryCount(0).R().
+		SetContext(ctx).
+		Get(c.config.Url + "/metadata")
+	if err != nil {
+		return fmt.Errorf("failed to reach the FHIR pseudonymizer: %w", err)
+	}
+	if !resp.IsSuccess() {
+		return fmt.Errorf("FHIR pseudonymizer metadata request returned %s", resp.Status())
+	}
+
+	return nil
+}
+
+// release frees the request's slot of the adaptive limit. Errors, retried
+// requests and overload responses reduce the limit.
+func (c *PsnClient) release(ctx context.Context, resp *resty.Response, err error, retries int, latency time.Duration) {
+	if c.limit == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		c.limit.Drop()
+		return
+	}
 
+	failed := err != nil ||
+		resp.StatusCode() == http.StatusTooManyRequests ||
+		resp.StatusCode() == http.StatusServiceUnavailable ||
+		retries > 0
+	c.limit.Release(latency, failed)
 }
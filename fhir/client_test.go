@@ -1,8 +1,16 @@
 package fhir
 
 import (
+	"context"
 	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"net/http"
+	"net/http/httptest"
 	"pseudonymous/config"
 	"testing"
 	"time"
@@ -39,3 +47,165 @@ func TestNewConfig(t *testing.T) {
 	assert.Equal(t, 5*time.Second, client.rest.RetryWaitTime)
 	assert.Equal(t, 15*time.Second, client.rest.RetryMaxWaitTime)
 }
+
+func TestSendPropagatesTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	var traceparent string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"resourceType":"Patient"}`))
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL})
+
+	_, _, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.Nil(t, err)
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "pseudonymize", spans[0].Name())
+	assert.Contains(t, traceparent, spans[0].SpanContext().TraceID().String())
+}
+
+func TestSendAdaptiveOverload(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL, Adaptive: config.Adaptive{Enabled: true, Min: 1, Max: 8}})
+	client.limit.limit = 8
+
+	_, _, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.Error(t, err)
+	assert.Equal(t, 4, client.limit.Limit())
+}
+
+func TestSendBreakerProbeReleased(t *testing.T) {
+	client := NewClient(config.Pseudonymizer{
+		CircuitBreaker: config.CircuitBreaker{Threshold: 1},
+		Adaptive:       config.Adaptive{Enabled: true, Min: 1, Max: 1},
+	})
+	client.breaker.probeInterval = time.Millisecond
+	client.breaker.Record(false)
+	// no free slot for the probe
+	assert.NoError(t, client.limit.Acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := client.Send(ctx, []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, client.breaker.probing)
+}
+
+func TestSendBreakerCancelled(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL, CircuitBreaker: config.CircuitBreaker{Threshold: 1}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := client.Send(ctx, []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, client.breaker.open)
+}
+
+func TestSendRateLimited(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL, RateLimit: config.RateLimit{Rate: 10, Burst: 1}})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		_, _, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+		assert.NoError(t, err)
+	}
+
+	// the burst allows one request, the others wait for 100ms each
+	assert.GreaterOrEqual(t, time.Since(start), 190*time.Millisecond)
+}
+
+func TestSendRateLimitedCancel(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL, RateLimit: config.RateLimit{Rate: 0.01, Burst: 1}})
+	_, _, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+	assert.NoError(t, err)
+
+	// the limiter is exhausted, cancel while waiting for the next token
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, _, err = client.Send(ctx, []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSendOAuth2TokenError(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer tokens.Close()
+
+	var requested bool
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requested = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL, Auth: &config.Auth{
+		OAuth2: &config.OAuth2{TokenUrl: tokens.URL, ClientId: "test", ClientSecret: "wrong"},
+	}})
+
+	_, _, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.ErrorContains(t, err, "failed to get access token for the FHIR pseudonymizer")
+	assert.False(t, requested)
+}
+
+func TestNewClientInvalidTls(t *testing.T) {
+
+	client := NewClient(config.Pseudonymizer{Tls: &config.Tls{CaFile: "missing.pem"}})
+
+	assert.Nil(t, client)
+}
+
+func TestCheckPseudonymizer(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/fhir/metadata" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	retry := config.Retry{Count: 3}
+	assert.NoError(t, NewClient(config.Pseudonymizer{Url: s.URL + "/fhir", Retry: retry}).Check(context.Background()))
+
+	// no retries
+	requests = 0
+	err := NewClient(config.Pseudonymizer{Url: s.URL + "/wrong", Retry: retry}).Check(context.Background())
+	assert.EqualError(t, err, "FHIR pseudonymizer metadata request returned 404 Not Found")
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,131 @@
+package fhir
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"pseudonymous/config"
+	"pseudonymous/ttp"
+	"reflect"
+	"sync"
+)
+
+// Clients are the service clients shared by the processors of multiple
+// projects. Projects with the same settings of a service share its client,
+// i.e. the MongoDB connection pool, rate limits and the circuit breaker. A nil
+// Clients creates new clients for each processor.
+type Clients struct {
+	mu            sync.Mutex
+	mongo         []mongoClient
+	gpas          []*ttp.GpasClient
+	pseudonymizer []*PsnClient
+}
+
+type mongoClient struct {
+	cfg    config.MongoDb
+	client *mongo.Client
+}
+
+func NewClients() *Clients {
+	return &Clients{}
+}
+
+// NewProcessor creates the processor of a project with the shared clients
+func (c *Clients) NewProcessor(cfg *config.AppConfig, project string) (*Processor, error) {
+	return newProcessor(cfg, project, c)
+}
+
+// Close disconnects the MongoDB clients
+func (c *Clients) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, m := range c.mongo {
+		errs = append(errs, m.client.Disconnect(ctx))
+	}
+	c.mongo = nil
+	return errors.Join(errs...)
+}
+
+// provider returns the provider of a project's databases. Only the connection
+// settings need to match to share the client.
+func (c *Clients) provider(cfg config.Provider, project string) *MongoFhirProvider {
+	if c == nil {
+		return NewProvider(cfg, project)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := config.MongoDb{Connection: cfg.MongoDb.Connection, ConnectTimeout: cfg.MongoDb.ConnectTimeout, Tls: cfg.MongoDb.Tls}
+	for _, m := range c.mongo {
+		if reflect.DeepEqual(m.cfg, key) {
+			return c.sharedProvider(m.client, cfg.MongoDb, project)
+		}
+	}
+
+	client, err := connect(cfg.MongoDb)
+	if err != nil {
+		return nil
+	}
+	c.mongo = append(c.mongo, mongoClient{cfg: key, client: client})
+	return c.sharedProvider(client, cfg.MongoDb, project)
+}
+
+func (c *Clients) sharedProvider(client *mongo.Client, cfg config.MongoDb, project string) *MongoFhirProvider {
+	p := newMongoProvider(client, cfg, project)
+	p.shared = true
+	return p
+}
+
+func (c *Clients) gpasClient(cfg config.Gpas) *ttp.GpasClient {
+	if c == nil {
+		return ttp.NewGpasClient(cfg)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, g := range c.gpas {
+		if reflect.DeepEqual(g.Config, cfg) {
+			return g
+		}
+	}
+
+	g := ttp.NewGpasClient(cfg)
+	if g != nil {
+		c.gpas = append(c.gpas, g)
+	}
+	return g
+}
+
+// pseudonymizerClient returns the pseudonymizer client. The rules are applied
+// by the processor and don't need to match.
+func (c *Clients) pseudonymizerClient(cfg config.Pseudonymizer) *PsnClient {
+	if c == nil {
+		return NewClient(cfg)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cfg
+	key.Rules = ""
+	for _, p := range c.pseudonymizer {
+		shared := p.config
+		shared.Rules = ""
+		if reflect.DeepEqual(shared, key) {
+			return p
+		}
+	}
+
+	p := NewClient(cfg)
+	if p != nil {
+		c.pseudonymizer = append(c.pseudonymizer, p)
+	}
+	return p
+}
@@ -0,0 +1,65 @@
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"pseudonymous/config"
+	"testing"
+)
+
+func TestClientsShared(t *testing.T) {
+	clients := NewClients()
+	cfg := &config.AppConfig{
+		Fhir: config.Fhir{
+			Provider:      config.Provider{MongoDb: config.MongoDb{Connection: "mongodb://localhost", ConnectTimeout: 1}},
+			Pseudonymizer: config.Pseudonymizer{Url: "http://localhost:5000/fhir"},
+		},
+		Gpas: config.Gpas{Url: "http://localhost:8080/gpas"},
+	}
+
+	a, err := clients.NewProcessor(cfg, "a")
+	assert.NoError(t, err)
+
+	// same service settings, other batch size and rules
+	b := *cfg
+	b.Fhir.Provider.MongoDb.BatchSize = 10
+	b.Fhir.Pseudonymizer.Rules = "../dev/anonymization.yaml"
+	pb, err := clients.NewProcessor(&b, "b")
+	assert.NoError(t, err)
+
+	// other pseudonymizer and gPAS
+	c := *cfg
+	c.Fhir.Pseudonymizer.Url = "http://pseudonymizer-c:5000/fhir"
+	c.Gpas.Url = "http://gpas-c:8080/gpas"
+	pc, err := clients.NewProcessor(&c, "c")
+	assert.NoError(t, err)
+
+	provA, provB := a.provider.(*MongoFhirProvider), pb.provider.(*MongoFhirProvider)
+	assert.Same(t, provA.Client, provB.Client)
+	assert.Equal(t, "idat_fhir_b", provB.Source.Name())
+	assert.Equal(t, 10, provB.batchSize)
+	assert.Same(t, a.pseudonymizer, pb.pseudonymizer)
+	assert.Same(t, a.gpas, pb.gpas)
+
+	assert.Same(t, provA.Client, pc.provider.(*MongoFhirProvider).Client)
+	assert.NotSame(t, a.pseudonymizer, pc.pseudonymizer)
+	assert.NotSame(t, a.gpas, pc.gpas)
+
+	// the shared client is disconnected once, by its owner
+	assert.NoError(t, a.Close())
+	assert.NoError(t, pb.Close())
+	assert.NoError(t, clients.Close())
+}
+
+func TestClientsNil(t *testing.T) {
+	var clients *Clients
+	cfg := &config.AppConfig{Fhir: config.Fhir{Provider: config.Provider{MongoDb: config.MongoDb{Connection: "mongodb://localhost"}}}}
+
+	a, err := clients.NewProcessor(cfg, "a")
+	assert.NoError(t, err)
+	b, err := clients.NewProcessor(cfg, "b")
+	assert.NoError(t, err)
+
+	assert.NotSame(t, a.provider.(*MongoFhirProvider).Client, b.provider.(*MongoFhirProvider).Client)
+	assert.NoError(t, a.Close())
+	assert.NoError(t, b.Close())
+}
@@ -0,0 +1,187 @@
+package fhir
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"pseudonymous/config"
+	"pseudonymous/tracing"
+	"pseudonymous/ttp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resolveBatchSize is the maximum number of pseudonyms per gPAS lookup
+const resolveBatchSize = 1000
+
+type Exporter struct {
+	provider *MongoFhirProvider
+	gpas     *ttp.GpasClient
+	project  string
+	resolve  bool
+}
+
+type ExportResult struct {
+	count    map[string]int
+	duration time.Duration
+}
+
+func NewExporter(config *config.AppConfig, project string, resolve bool) (*Exporter, error) {
+	gpas := ttp.NewGpasClient(config.Gpas)
+	if gpas == nil {
+		return nil, errors.New("failed to initialize gPAS client")
+	}
+
+	prov := NewProvider(config.Fhir.Provider, project)
+	if prov == nil {
+		return nil, errors.New("failed to initialize Provider")
+	}
+	return &Exporter{
+		provider: prov,
+		gpas:     gpas,
+		project:  project,
+		resolve:  resolve,
+	}, nil
+}
+
+func (e *Exporter) Close() error {
+	return e.provider.Close()
+}
+
+// Export collects all pseudonyms found in the destination database per gPAS
+// domain and writes them as CSV to w
+func (e *Exporter) Export(w io.Writer) (ExportResult, error) {
+	start := time.Now()
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "export",
+		trace.WithAttributes(attribute.String("project", e.project)))
+	defer span.End()
+
+	domains, err := e.gpas.Domains(e.project)
+	if err != nil {
+		slog.Error("Invalid gPAS domain configuration", "error", err.Error())
+		return ExportResult{}, err
+	}
+	// match the most specific psn prefix first
+	sort.SliceStable(domains, func(i, j int) bool {
+		return len(domains[i].Config.PsnPrefix) > len(domains[j].Config.PsnPrefix)
+	})
+
+	resources := make(chan MongoResource)
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("Reading pseudonymized resources", "provider", e.provider.Name())
+		errs <- e.provider.ReadPseudonymized(ctx, resources)
+		close(resources)
+	}()
+
+	psns := make(map[string]map[string]struct{})
+	for r := range resources {
+		collectPseudonyms(r.Fhir, domains, psns)
+	}
+	if err := <-errs; err != nil {
+		slog.Error("Failed to read data", "error", err.Error())
+		return ExportResult{}, err
+	}
+
+	count, err := e.write(ctx, w, psns)
+	if err != nil {
+		return ExportResult{}, err
+	}
+	end := time.Since(start)
+
+	slog.Info("Finished exporting pseudonyms", "count", convertToString(count), "duration", end)
+
+	return ExportResult{count: count, duration: end}, nil
+}
+
+func (e *Exporter) write(ctx context.Context, w io.Writer, psns map[string]map[string]struct{}) (map[string]int, error) {
+	out := csv.NewWriter(w)
+
+	header := []string{"domain", "pseudonym"}
+	if e.resolve {
+		header = append(header, "original")
+	}
+	if err := out.Write(header); err != nil {
+		return nil, err
+	}
+
+	domainNames := make([]string, 0, len(psns))
+	for d := range psns {
+		domainNames = append(domainNames, d)
+	}
+	slices.Sort(domainNames)
+
+	count := make(map[string]int)
+	for _, domain := range domainNames {
+		values := make([]string, 0, len(psns[domain]))
+		for v := range psns[domain] {
+			values = append(values, v)
+		}
+		slices.Sort(values)
+
+		for batch := range slices.Chunk(values, resolveBatchSize) {
+			var originals map[string]string
+			if e.resolve {
+				var err error
+				originals, err = e.gpas.ResolvePseudonyms(ctx, domain, batch)
+				if err != nil {
+					slog.Error("Failed to resolve pseudonyms", "domain", domain, "error", err.Error())
+					return nil, err
+				}
+			}
+
+			for _, psn := range batch {
+				record := []string{domain, psn}
+				if e.resolve {
+					record = append(record, originals[psn])
+				}
+				if err := out.Write(record); err != nil {
+					return nil, err
+				}
+			}
+		}
+		count[domain] = len(values)
+	}
+
+	out.Flush()
+	return count, out.Error()
+}
+
+// collectPseudonyms walks a resource and adds all string values matching a
+// domain's psn prefix to the domain's set of pseudonyms
+func collectPseudonyms(v interface{}, domains []ttp.DomainDTO, psns map[string]map[string]struct{}) {
+	switch val := v.(type) {
+	case bson.M:
+		for _, e := range val {
+			collectPseudonyms(e, domains, psns)
+		}
+	case bson.D:
+		for _, e := range val {
+			collectPseudonyms(e.Value, domains, psns)
+		}
+	case bson.A:
+		for _, e := range val {
+			collectPseudonyms(e, domains, psns)
+		}
+	case string:
+		for _, d := range domains {
+			if strings.HasPrefix(val, d.Config.PsnPrefix) {
+				set, exists := psns[d.Name]
+				if !exists {
+					set = make(map[string]struct{})
+					psns[d.Name] = set
+				}
+				set[val] = struct{}{}
+				return
+			}
+		}
+	}
+}
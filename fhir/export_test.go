@@ -0,0 +1,93 @@
+package fhir
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"net/http"
+	"net/http/httptest"
+	"pseudonymous/config"
+	"pseudonymous/ttp"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+
+		provider := &MongoFhirProvider{
+			Client:      mt.Client,
+			Source:      mt.DB,
+			Destination: mt.DB,
+			name:        "MongoDB Test Provider",
+		}
+
+		// gpas psn service (resolve)
+		s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+			res.WriteHeader(http.StatusOK)
+			_, _ = res.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+<soap:Body><ns2:getValueForListResponse xmlns:ns2="http://psn.ttp.ganimed.icmvc.emau.org/">
+<return><entry><key>PSN-TEST-PATIENT-AAAA</key><value>p1</value></entry></return>
+</ns2:getValueForListResponse></soap:Body></soap:Envelope>`))
+		}))
+		defer s.Close()
+
+		e := &Exporter{
+			provider: provider,
+			gpas: ttp.NewGpasClient(config.Gpas{
+				PsnUrl:  s.URL,
+				Domains: config.Domains{Config: []config.Domain{{Name: "patient", Prefix: "PATIENT"}}},
+			}),
+			project: "test",
+			resolve: true,
+		}
+
+		pat := MongoResource{
+			Id: primitive.NewObjectID(),
+			Fhir: bson.M{
+				"resourceType": "Patient",
+				"identifier":   bson.A{bson.M{"value": "PSN-TEST-PATIENT-AAAA"}},
+			},
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, bson.D{{Key: "name", Value: "Patient"}}),
+			mtest.CreateCursorResponse(0, "test.Patient", mtest.FirstBatch, toDoc(pat)),
+		)
+
+		// act
+		out := new(bytes.Buffer)
+		result, err := e.Export(out)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]int{"test-patient": 1}, result.count)
+		assert.Equal(t, "domain,pseudonym,original\ntest-patient,PSN-TEST-PATIENT-AAAA,p1\n", out.String())
+	})
+}
+
+func TestCollectPseudonyms(t *testing.T) {
+	domains, _ := ttp.NewGpasClient(config.Gpas{
+		Domains: config.Domains{Config: []config.Domain{{Name: "patient", Prefix: "PATIENT"}}},
+	}).Domains("test")
+
+	res := bson.M{
+		"id": "PSN-TEST-AAAA",
+		"identifier": bson.A{
+			bson.M{"value": "PSN-TEST-PATIENT-BBBB"},
+			bson.D{{Key: "value", Value: "other"}},
+		},
+	}
+	psns := make(map[string]map[string]struct{})
+
+	// child domain prefixes take precedence over the project prefix
+	collectPseudonyms(res, []ttp.DomainDTO{domains[1], domains[0]}, psns)
+
+	assert.Equal(t, map[string]map[string]struct{}{
+		"test":         {"PSN-TEST-AAAA": {}},
+		"test-patient": {"PSN-TEST-PATIENT-BBBB": {}},
+	}, psns)
+}
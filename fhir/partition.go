@@ -0,0 +1,105 @@
+package fhir
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math/big"
+)
+
+// partition is a range of a collection read by its own cursor
+type partition struct {
+	collection *mongo.Collection
+	filter     bson.M
+}
+
+// partitions splits the _id range of a collection into n partitions of
+// roughly the same width. The bounds are interpolated between the smallest
+// and largest _id, so the partitions are only balanced for evenly
+// distributed ids.
+func partitions(ctx context.Context, collection *mongo.Collection, n int) ([]partition, error) {
+	if n <= 1 {
+		return []partition{{collection: collection, filter: bson.M{}}}, nil
+	}
+
+	first, err := boundaryId(ctx, collection, 1)
+	if err != nil {
+		return nil, err
+	}
+	last, err := boundaryId(ctx, collection, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	filters := rangeFilters(splitIds(first, last, n))
+	result := make([]partition, 0, len(filters))
+	for _, f := range filters {
+		result = append(result, partition{collection: collection, filter: f})
+	}
+
+	return result, nil
+}
+
+// boundaryId returns the smallest (order 1) or largest (order -1) _id of a
+// collection. An empty collection has no bounds.
+func boundaryId(ctx context.Context, collection *mongo.Collection, order int) (primitive.ObjectID, error) {
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "_id", Value: order}}).
+		SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+	var doc struct {
+		Id primitive.ObjectID `bson:"_id"`
+	}
+	err := collection.FindOne(ctx, bson.M{}, opts).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return primitive.NilObjectID, nil
+	}
+
+	return doc.Id, err
+}
+
+// splitIds returns up to n-1 distinct ids splitting the range from first to
+// last into n parts
+func splitIds(first, last primitive.ObjectID, n int) []primitive.ObjectID {
+	lo := new(big.Int).SetBytes(first[:])
+	hi := new(big.Int).SetBytes(last[:])
+	width := new(big.Int).Sub(hi, lo)
+	if width.Sign() <= 0 {
+		return nil
+	}
+
+	var bounds []primitive.ObjectID
+	for i := 1; i < n; i++ {
+		v := new(big.Int).Mul(width, big.NewInt(int64(i)))
+		v.Div(v, big.NewInt(int64(n)))
+		v.Add(v, lo)
+
+		var id primitive.ObjectID
+		v.FillBytes(id[:])
+		if id != first && (len(bounds) == 0 || bounds[len(bounds)-1] != id) {
+			bounds = append(bounds, id)
+		}
+	}
+
+	return bounds
+}
+
+// rangeFilters returns the _id filters of the ranges between the bounds. The
+// first and last range are open, so ids added during the run are still read.
+func rangeFilters(bounds []primitive.ObjectID) []bson.M {
+	if len(bounds) == 0 {
+		return []bson.M{{}}
+	}
+
+	filters := make([]bson.M, 0, len(bounds)+1)
+	filters = append(filters, bson.M{"_id": bson.M{"$lt": bounds[0]}})
+	for i := 1; i < len(bounds); i++ {
+		filters = append(filters, bson.M{"_id": bson.M{"$gte": bounds[i-1], "$lt": bounds[i]}})
+	}
+	filters = append(filters, bson.M{"_id": bson.M{"$gte": bounds[len(bounds)-1]}})
+
+	return filters
+}
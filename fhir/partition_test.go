@@ -0,0 +1,117 @@
+package fhir
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"testing"
+)
+
+func objectId(hex string) primitive.ObjectID {
+	id, _ := primitive.ObjectIDFromHex(hex)
+	return id
+}
+
+func TestSplitIds(t *testing.T) {
+	cases := []struct {
+		name     string
+		first    primitive.ObjectID
+		last     primitive.ObjectID
+		n        int
+		expected []primitive.ObjectID
+	}{
+		{
+			name:  "even",
+			first: objectId("000000000000000000000000"),
+			last:  objectId("000000000000000000000400"),
+			n:     4,
+			expected: []primitive.ObjectID{
+				objectId("000000000000000000000100"),
+				objectId("000000000000000000000200"),
+				objectId("000000000000000000000300"),
+			},
+		},
+		{
+			name:     "narrow",
+			first:    objectId("000000000000000000000001"),
+			last:     objectId("000000000000000000000003"),
+			n:        4,
+			expected: []primitive.ObjectID{objectId("000000000000000000000002")},
+		},
+		{
+			name:     "single",
+			first:    objectId("000000000000000000000001"),
+			last:     objectId("000000000000000000000001"),
+			n:        4,
+			expected: nil,
+		},
+		{
+			name:     "empty",
+			first:    primitive.NilObjectID,
+			last:     primitive.NilObjectID,
+			n:        4,
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, splitIds(c.first, c.last, c.n))
+		})
+	}
+}
+
+func TestRangeFilters(t *testing.T) {
+	a := objectId("000000000000000000000100")
+	b := objectId("000000000000000000000200")
+
+	assert.Equal(t, []bson.M{{}}, rangeFilters(nil))
+	assert.Equal(t, []bson.M{
+		{"_id": bson.M{"$lt": a}},
+		{"_id": bson.M{"$gte": a, "$lt": b}},
+		{"_id": bson.M{"$gte": b}},
+	}, rangeFilters([]primitive.ObjectID{a, b}))
+}
+
+func TestPartitions(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("single", func(mt *mtest.T) {
+		parts, err := partitions(context.Background(), mt.Coll, 1)
+
+		assert.NoError(mt, err)
+		assert.Equal(mt, []partition{{collection: mt.Coll, filter: bson.M{}}}, parts)
+	})
+
+	mt.Run("range", func(mt *mtest.T) {
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: objectId("000000000000000000000000")}}),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: objectId("000000000000000000000200")}}),
+		)
+
+		parts, err := partitions(context.Background(), mt.Coll, 2)
+
+		assert.NoError(mt, err)
+		assert.Len(mt, parts, 2)
+		assert.Equal(mt, bson.M{"_id": bson.M{"$lt": objectId("000000000000000000000100")}}, parts[0].filter)
+		assert.Equal(mt, bson.M{"_id": bson.M{"$gte": objectId("000000000000000000000100")}}, parts[1].filter)
+	})
+
+	mt.Run("empty", func(mt *mtest.T) {
+		ns := mt.DB.Name() + "." + mt.Coll.Name()
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch),
+		)
+
+		parts, err := partitions(context.Background(), mt.Coll, 2)
+
+		assert.NoError(mt, err)
+		assert.Equal(mt, []partition{{collection: mt.Coll, filter: bson.M{}}}, parts)
+	})
+}
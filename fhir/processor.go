@@ -2,47 +2,124 @@ package fhir
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"pseudonymous/config"
+	"pseudonymous/metrics"
+	"pseudonymous/tracing"
 	"pseudonymous/ttp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
+// defaultGracePeriod is the time given to resources in flight on shutdown, if
+// not configured
+const defaultGracePeriod = 30 * time.Second
+
+// ErrCheckFailed is returned if the preflight check before a run fails
+var ErrCheckFailed = errors.New("preflight check failed")
+
 type Processor struct {
-	provider      Provider
-	pseudonymizer *PsnClient
-	project       string
-	concurrency   int
-	gpas          *ttp.GpasClient
+	provider         Provider
+	pseudonymizer    *PsnClient
+	project          string
+	concurrency      int
+	writeConcurrency int
+	buffers          config.Buffers
+	gpas             *ttp.GpasClient
+	rules            []Rule
+	progress         config.Progress
+	gracePeriod      time.Duration
+	statusFile       string
+	// preflight checks connectivity before the run
+	preflight bool
+	// retries of the current run
+	retries *Retries
+}
+
+// pseudonymized is a pseudonymized resource handed over to the writers. The
+// span of the resource ends when written.
+type pseudonymized struct {
+	resource MongoResource
+	span     trace.Span
 }
 
 type ProcessResult struct {
 	count    map[string]int
+	retries  map[string]RetryStats
 	duration time.Duration
 }
 
+// NewProcessor creates the processor of a project with its own clients
 func NewProcessor(config *config.AppConfig, project string) (*Processor, error) {
+	return newProcessor(config, project, nil)
+}
+
+func newProcessor(config *config.AppConfig, project string, clients *Clients) (*Processor, error) {
 	concurrency := config.App.Concurrency
 	if concurrency == 0 {
 		concurrency = 1
 	}
+	// enough workers to make use of the adaptive limit
+	if config.Fhir.Pseudonymizer.Adaptive.Enabled {
+		concurrency = max(concurrency, config.Fhir.Pseudonymizer.Adaptive.Max)
+	}
+	writeConcurrency := config.App.WriteConcurrency
+	if writeConcurrency == 0 {
+		writeConcurrency = concurrency
+	}
+	// a negative grace period cancels resources in flight immediately
+	gracePeriod := time.Duration(config.App.GracePeriod) * time.Second
+	if gracePeriod == 0 {
+		gracePeriod = defaultGracePeriod
+	}
+
+	gpas := clients.gpasClient(config.Gpas)
+	if gpas == nil {
+		return nil, errors.New("failed to initialize gPAS client")
+	}
+
+	var rules []Rule
+	if config.Fhir.Pseudonymizer.Rules != "" {
+		var err error
+		rules, err = LoadRules(config.Fhir.Pseudonymizer.Rules)
+		if err != nil {
+			slog.Error("Failed to load pseudonymizer rules", "file", config.Fhir.Pseudonymizer.Rules, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	pseudonymizer := clients.pseudonymizerClient(config.Fhir.Pseudonymizer)
+	if pseudonymizer == nil {
+		return nil, errors.New("failed to initialize FHIR pseudonymizer client")
+	}
 
-	prov := NewProvider(config.Fhir.Provider, project)
+	prov := clients.provider(config.Fhir.Provider, project)
 	if prov == nil {
 		return nil, errors.New("failed to initialize Provider")
 	}
 	return &Processor{
-		provider:      prov,
-		pseudonymizer: NewClient(config.Fhir.Pseudonymizer),
-		gpas:          ttp.NewGpasClient(config.Gpas),
-		project:       project,
-		concurrency:   concurrency,
+		provider:         prov,
+		pseudonymizer:    pseudonymizer,
+		gpas:             gpas,
+		project:          project,
+		concurrency:      concurrency,
+		writeConcurrency: writeConcurrency,
+		buffers:          config.App.Buffers,
+		rules:            rules,
+		progress:         config.App.Progress,
+		gracePeriod:      max(0, gracePeriod),
+		statusFile:       config.App.StatusFile,
+		preflight:        config.App.Preflight,
 	}, nil
 }
 
@@ -50,56 +127,138 @@ func (p *Processor) Close() error {
 	return p.provider.Close()
 }
 
-func (p *Processor) Pseudonymize(resource bson.M) ([]byte, error) {
+// Pseudonymize returns the pseudonymized resource and the number of retries
+// needed
+func (p *Processor) Pseudonymize(ctx context.Context, resource bson.M) ([]byte, int, error) {
 
 	resData, err := json.Marshal(resource)
 	if err != nil {
 		slog.Error("Unable to marshal resource to JSON", "error", err.Error())
-		return nil, err
+		return nil, 0, err
 	}
 
-	resp, err := p.pseudonymizer.Send(resData, p.project+"-")
+	resp, retries, err := p.pseudonymizer.Send(ctx, resData, p.project+"-")
 	if err != nil {
 		slog.Error("Failed to pseudonymize resource", "error", err.Error())
-		return nil, err
+		return nil, retries, err
 	}
 
-	return resp, nil
+	return resp, retries, nil
 }
 
-func (p *Processor) Run() (ProcessResult, error) {
+// Run processes all resources of the provider until done or ctx is cancelled.
+// On cancellation, reading stops immediately while resources in flight are
+// given the grace period to finish. The final status of the run is saved in
+// any case.
+func (p *Processor) Run(ctx context.Context) (ProcessResult, error) {
 	start := time.Now()
 
+	result, err := p.run(ctx, start)
+
+	status := NewRunStatus(p.project, start, result, err)
+	if saveErr := status.Save(p.statusFile); saveErr != nil {
+		slog.Error("Failed to save run status", "file", p.statusFile, "error", saveErr.Error())
+	}
+
+	return result, err
+}
+
+func (p *Processor) run(ctx context.Context, start time.Time) (ProcessResult, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "run",
+		trace.WithAttributes(attribute.String("project", p.project)))
+	defer span.End()
+
+	p.retries = NewRetries()
+
+	// workers abort the run if the pseudonymizer stays unavailable
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+
+	if p.preflight {
+		if err := p.Check(ctx); err != nil {
+			slog.Error("Preflight check failed", "project", p.project, "error", err.Error())
+			return ProcessResult{}, fmt.Errorf("%w: %w", ErrCheckFailed, err)
+		}
+	}
+
 	if p.gpas.Config.Domains.AutoCreate {
-		err := p.gpas.SetupDomains(p.project)
+		err := p.gpas.SetupDomains(ctx, p.project)
 		if err != nil {
 			return ProcessResult{}, err
 		}
 		slog.Info("gPAS domains initialized", "project", p.project)
 	}
 
-	wg := new(sync.WaitGroup)
-	jobs := make(chan MongoResource)
-	results := make(chan string)
+	if p.gpas.Config.Domains.Verify {
+		if err := p.VerifyDomains(ctx); err != nil {
+			return ProcessResult{}, err
+		}
+	}
 
-	concurrency := p.concurrency
-	for i := 0; i < concurrency; i++ {
-		wg.Add(1)
-		go p.createWorker(wg, jobs, results)
+	counts, err := p.provider.Count(ctx)
+	if err != nil {
+		slog.Warn("Failed to count resources, progress is reported without totals", "error", err.Error())
 	}
-	slog.Info("Worker created", "concurrency", concurrency)
+	progress := NewProgress(p.project, counts)
+
+	interval := time.Duration(p.progress.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	stopReport := make(chan struct{})
+	reported := make(chan struct{})
+	go func() {
+		progress.Report(interval, p.progress.Bar, stopReport)
+		close(reported)
+	}()
+
+	// resources in flight are cancelled after the grace period on shutdown
+	workCtx, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	stopGrace := context.AfterFunc(ctx, func() {
+		slog.Warn("Shutting down, waiting for resources in flight", "gracePeriod", p.gracePeriod)
+		time.AfterFunc(p.gracePeriod, cancelWork)
+	})
+	defer stopGrace()
+
+	// stages: read -> jobs -> pseudonymize -> writes -> write -> results
+	jobs := make(chan MongoResource, max(0, p.buffers.Jobs))
+	writes := make(chan pseudonymized, max(0, p.buffers.Writes))
+	results := make(chan string, max(0, p.buffers.Results))
+
+	stopQueues := make(chan struct{})
+	go observeQueues(map[string]func() int{
+		"jobs":    func() int { return len(jobs) },
+		"writes":  func() int { return len(writes) },
+		"results": func() int { return len(results) },
+	}, stopQueues)
+	defer close(stopQueues)
+
+	workers := new(sync.WaitGroup)
+	for i := 0; i < p.concurrency; i++ {
+		workers.Add(1)
+		go p.createWorker(workCtx, workers, jobs, writes, progress, abort)
+	}
+	writers := new(sync.WaitGroup)
+	for i := 0; i < max(1, p.writeConcurrency); i++ {
+		writers.Add(1)
+		go p.createWriter(workCtx, writers, writes, results, progress)
+	}
+	slog.Info("Worker created", "concurrency", p.concurrency, "writeConcurrency", max(1, p.writeConcurrency))
 
 	go func() {
 		slog.Info("Reading resources", "provider", p.provider.Name())
-		err := p.provider.Read(jobs)
-		if err != nil {
+		err := p.provider.Read(ctx, jobs)
+		if err != nil && ctx.Err() == nil {
 			slog.Error("Failed to read data", "error", err.Error())
 		}
 
-		// wait for resources to be processed
+		// wait for resources to be pseudonymized
 		close(jobs)
-		// wait for results
-		wg.Wait()
+		workers.Wait()
+		// wait for resources to be written
+		close(writes)
+		writers.Wait()
 		close(results)
 	}()
 
@@ -107,52 +266,203 @@ func (p *Processor) Run() (ProcessResult, error) {
 	m := make(map[string]int)
 	for r := range results {
 		m[r]++
+		progress.Add(r)
 	}
+	close(stopReport)
+	<-reported
 	end := time.Since(start)
 
+	result := ProcessResult{count: m, retries: p.retries.Stats(), duration: end}
+	if cause := context.Cause(ctx); errors.Is(cause, ErrPseudonymizerUnavailable) {
+		slog.Error("Processing aborted", "count", convertToString(m), "duration", end, "error", cause.Error())
+		return result, fmt.Errorf("processing aborted: %w", cause)
+	}
+	if ctx.Err() != nil {
+		slog.Warn("Processing interrupted", "count", convertToString(m), "duration", end)
+		return result, fmt.Errorf("processing interrupted: %w", context.Cause(ctx))
+	}
+
 	slog.Info("Finished processing results", "count", convertToString(m), "duration", end)
 
-	return ProcessResult{count: m, duration: end}, nil
+	return result, nil
 }
 
-func (p *Processor) createWorker(wg *sync.WaitGroup, jobs <-chan MongoResource, results chan string) {
-	defer wg.Done()
+// Check verifies the connectivity to MongoDB, the pseudonymizer and gPAS. All
+// problems found are returned.
+func (p *Processor) Check(ctx context.Context) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "check")
+	defer func() { tracing.End(span, err) }()
 
-	for r := range jobs {
+	var errs []error
+	if err := p.provider.Check(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := p.pseudonymizer.Check(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := p.gpas.Check(ctx); err != nil {
+		errs = append(errs, err)
+	}
 
-		// pseudonymize
-		psnResource, err := p.Pseudonymize(r.Fhir)
-		if err != nil {
-			return
+	err = errors.Join(errs...)
+	return err
+}
+
+// VerifyDomains fails if any gPAS domain required for the resource types of
+// the source doesn't exist
+func (p *Processor) VerifyDomains(ctx context.Context) error {
+	resourceTypes, err := p.provider.ResourceTypes(ctx)
+	if err != nil {
+		slog.Error("Failed to get resource types", "provider", p.provider.Name(), "error", err.Error())
+		return err
+	}
+
+	domains := p.requiredDomains(resourceTypes)
+	if err = p.gpas.VerifyDomains(ctx, domains); err != nil {
+		slog.Error("Failed to verify gPAS domains", "project", p.project, "error", err.Error())
+		return err
+	}
+
+	slog.Info("gPAS domains verified", "project", p.project, "domains", strings.Join(domains, ","))
+	return nil
+}
+
+// requiredDomains returns the names of the gPAS domains used for the given
+// resource types: the domains configured for the resource types and the
+// domains referenced by the pseudonymizer rules
+func (p *Processor) requiredDomains(resourceTypes []string) []string {
+	required := make(map[string]struct{})
+
+	for _, d := range p.gpas.Config.Domains.Config {
+		for _, t := range d.ResourceTypes {
+			if slices.Contains(resourceTypes, t) {
+				required[d.Name] = struct{}{}
+			}
 		}
+	}
 
-		// unmarshal result
-		var fhirBson bson.M
-		err = bson.UnmarshalExtJSON(psnResource, true, &fhirBson)
-		if err != nil {
-			slog.Error("Failed to convert psn data to BSON", "error", err.Error())
+	for _, r := range p.rules {
+		if !r.Pseudonymizes() {
 			continue
 		}
-
-		// save result
-		psnResult := MongoResource{
-			Id:         r.Id,
-			Fhir:       fhirBson,
-			Collection: r.Collection,
+		if t := r.ResourceType(); t == "" || slices.Contains(resourceTypes, t) {
+			required[r.Domain] = struct{}{}
 		}
-		err = p.provider.Write(psnResult)
-		if err != nil {
-			slog.Error("Failed to save psn data to database collection",
-				"id", psnResult.Id,
-				"collection", psnResult.Collection.Name(),
-				"error", err.Error())
-			continue
+	}
+
+	domains := make([]string, 0, len(required))
+	for d := range required {
+		domains = append(domains, p.project+"-"+d)
+	}
+	slices.Sort(domains)
+
+	return domains
+}
+
+// createWorker pseudonymizes resources from jobs until the channel is closed.
+// Each resource is traced separately, linked to the span of the run. The run
+// is aborted if the pseudonymizer is unavailable.
+func (p *Processor) createWorker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan MongoResource, writes chan<- pseudonymized, progress *Progress, abort context.CancelCauseFunc) {
+	defer wg.Done()
+
+	for r := range jobs {
+		if err := p.process(ctx, r, writes, progress); errors.Is(err, ErrPseudonymizerUnavailable) {
+			abort(err)
+			return
 		}
+	}
+}
+
+// createWriter saves pseudonymized resources from writes until the channel is
+// closed
+func (p *Processor) createWriter(ctx context.Context, wg *sync.WaitGroup, writes <-chan pseudonymized, results chan<- string, progress *Progress) {
+	defer wg.Done()
+
+	for w := range writes {
+		p.write(ctx, w, results, progress)
+	}
+}
+
+// process pseudonymizes a resource and hands it over to the writers. It
+// returns the error if the resource couldn't be pseudonymized. Failed resources
+// are counted as done in the progress.
+func (p *Processor) process(runCtx context.Context, r MongoResource, writes chan<- pseudonymized, progress *Progress) error {
+	metrics.WorkersInFlight.Inc()
+	defer metrics.WorkersInFlight.Dec()
+
+	collection := r.Collection.Name()
+	// the resource is cancelled with the run after the grace period, but traced
+	// separately
+	ctx, span := tracing.Tracer().Start(runCtx, "process resource",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(runCtx)),
+		trace.WithAttributes(attribute.String("collection", collection), attribute.String("id", r.Id.Hex())))
+
+	// pseudonymize
+	psnResource, retries, err := p.Pseudonymize(ctx, r.Fhir)
+	p.retries.Add(collection, retries)
+	if err != nil {
+		metrics.ResourcesFailed.WithLabelValues(collection, "pseudonymize").Inc()
+		progress.Add(collection)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return err
+	}
+	metrics.ResourcesPseudonymized.WithLabelValues(collection).Inc()
+
+	// unmarshal result
+	var fhirBson bson.M
+	err = bson.UnmarshalExtJSON(psnResource, true, &fhirBson)
+	if err != nil {
+		slog.Error("Failed to convert psn data to BSON", "error", err.Error())
+		metrics.ResourcesFailed.WithLabelValues(collection, "convert").Inc()
+		progress.Add(collection)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil
+	}
+
+	psnResult := MongoResource{
+		Id:         r.Id,
+		Fhir:       fhirBson,
+		Collection: r.Collection,
+	}
+	if err = enqueue(runCtx, "pseudonymize", writes, pseudonymized{resource: psnResult, span: span}); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+	}
+	return nil
+}
+
+// write saves a pseudonymized resource and reports the result. Failed writes
+// are counted as done in the progress.
+func (p *Processor) write(runCtx context.Context, w pseudonymized, results chan<- string, progress *Progress) {
+	metrics.WritersInFlight.Inc()
+	defer metrics.WritersInFlight.Dec()
+
+	span := w.span
+	defer span.End()
+	ctx := trace.ContextWithSpan(runCtx, span)
+
+	psnResult := w.resource
+	collection := psnResult.Collection.Name()
+	err := p.provider.Write(ctx, psnResult)
+	if err != nil {
+		slog.Error("Failed to save psn data to database collection",
+			"id", psnResult.Id,
+			"collection", psnResult.Collection.Name(),
+			"error", err.Error())
+		metrics.ResourcesFailed.WithLabelValues(collection, "write").Inc()
+		progress.Add(collection)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
 
-		slog.Debug("Successfully processed resource", "_id", psnResult.Id, "collections", psnResult.Collection.Name())
+	slog.Debug("Successfully processed resource", "_id", psnResult.Id, "collections", psnResult.Collection.Name())
 
-		// send result
-		results <- psnResult.Collection.Name()
+	// send result
+	if err = enqueue(runCtx, "write", results, collection); err != nil {
+		span.SetStatus(codes.Error, err.Error())
 	}
 }
 
@@ -2,17 +2,24 @@ package fhir
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"go.opentelemetry.io/otel/trace"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"pseudonymous/config"
 	"pseudonymous/ttp"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestRun(t *testing.T) {
@@ -20,18 +27,151 @@ func TestRun(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 
 	mt.Run("success", func(mt *mtest.T) {
+		// separate mock deployment for the destination database, so concurrent reads
+		// and writes don't compete for the same mock responses
+		dest := mtest.New(mt.T, mtest.NewOptions().ClientType(mtest.Mock))
+		dest.Run("destination", func(dest *mtest.T) {
+			runSuccess(mt, dest)
+		})
+	})
+}
+
+func runSuccess(mt *mtest.T, dest *mtest.T) {
+	provider := &MongoFhirProvider{
+		Client:      mt.Client,
+		Source:      mt.DB,
+		Destination: dest.DB,
+		name:        "MongoDB Test Provider",
+	}
+
+	// gpas soap client (domain setup)
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	p := &Processor{
+		provider:      provider,
+		pseudonymizer: NewClient(config.Pseudonymizer{}),
+		project:       "test",
+		gpas:          ttp.NewGpasClient(config.Gpas{Url: s.URL}),
+		concurrency:   1,
+	}
+
+	// test resources
+	pat := MongoResource{
+		Id:         primitive.ObjectID{},
+		Fhir:       bson.M{"resourceType": "Patient"},
+		Collection: nil,
+	}
+	obs := MongoResource{
+		Id:         primitive.ObjectID{},
+		Fhir:       bson.M{"resourceType": "Observation"},
+		Collection: nil,
+	}
+
+	collNames := []bson.D{{{Key: "name", Value: "Patient"}}, {{Key: "name", Value: "Observation"}}}
+
+	// expect one Patient and one Observation in results
+	expResultCount := map[string]int{"Patient": 1, "Observation": 1}
+
+	// setup mocks
+	// mongodb
+	mt.AddMockResponses(
+		// count resources
+		mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames...),
+		mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		// list collections and read data
+		mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames...),
+		mtest.CreateCursorResponse(0, "test.Patient", mtest.FirstBatch, toDoc(pat)),
+		mtest.CreateCursorResponse(0, "test.Observation", mtest.FirstBatch, toDoc(obs)),
+	)
+	dest.AddMockResponses(
+		// save data back
+		mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+	)
+
+	// rest client (pseudonymization)
+	httpmock.ActivateNonDefault(p.pseudonymizer.rest.GetClient())
+	httpmock.RegisterResponder("POST", "/$de-identify", func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return httpmock.NewStringResponse(400, ""), nil
+		}
+		// just return the request body
+		// it's a Parameters resource, but that doesn't matter here
+		return httpmock.NewBytesResponse(200, body), nil
+	})
+
+	// act
+	result, err := p.Run(context.Background())
+
+	assert.Nil(mt, err)
+	assert.Equal(mt, expResultCount, result.count)
+}
+
+func toDoc(v interface{}) (doc bson.D) {
+	data, _ := bson.Marshal(v)
+
+	_ = bson.Unmarshal(data, &doc)
+	return
+}
+
+func TestNewProcessor(t *testing.T) {
+	p, _ := NewProcessor(&config.AppConfig{Fhir: config.Fhir{
+		Pseudonymizer: config.Pseudonymizer{},
+		Provider: config.Provider{
+			MongoDb: config.MongoDb{
+				Connection: "mongodb://localhost",
+			},
+		},
+	}}, "test")
+
+	assert.Equal(t, 1, p.concurrency)
+	assert.Equal(t, 1, p.writeConcurrency)
+}
+
+func TestRequiredDomains(t *testing.T) {
+
+	p := &Processor{
+		project: "test",
+		gpas: ttp.NewGpasClient(config.Gpas{Domains: config.Domains{Config: []config.Domain{
+			{Name: "patient", ResourceTypes: []string{"Patient"}},
+			{Name: "encounter", ResourceTypes: []string{"Encounter"}},
+		}}}),
+		rules: []Rule{
+			{Path: "Observation.identifier.value", Method: "pseudonymize", Domain: "lab-report"},
+			{Path: "nodesByType('Identifier').value", Method: "pseudonymize", Domain: "visit"},
+			{Path: "Patient.id", Method: "cryptoHash"},
+		},
+	}
+
+	domains := p.requiredDomains([]string{"Patient"})
+
+	assert.Equal(t, []string{"test-patient", "test-visit"}, domains)
+}
+
+func TestRunMissingDomains(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("missing domains", func(mt *mtest.T) {
 
 		provider := &MongoFhirProvider{
 			Client:      mt.Client,
-			Context:     context.Background(),
 			Source:      mt.DB,
 			Destination: mt.DB,
 			name:        "MongoDB Test Provider",
 		}
 
-		// gpas soap client (domain setup)
+		// gpas soap client: all domains are unknown
 		s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
-			res.WriteHeader(http.StatusOK)
+			res.WriteHeader(http.StatusInternalServerError)
+			_, _ = res.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>
+<soap:Fault><faultcode>soap:Server</faultcode><faultstring>domain not found</faultstring>
+<detail><ns2:UnknownDomainException xmlns:ns2="http://psn.ttp.ganimed.icmvc.emau.org/"/></detail>
+</soap:Fault></soap:Body></soap:Envelope>`))
 		}))
 		defer s.Close()
 
@@ -39,78 +179,307 @@ func TestRun(t *testing.T) {
 			provider:      provider,
 			pseudonymizer: NewClient(config.Pseudonymizer{}),
 			project:       "test",
-			gpas:          ttp.NewGpasClient(config.Gpas{Url: s.URL}),
+			gpas: ttp.NewGpasClient(config.Gpas{Url: s.URL, Domains: config.Domains{
+				Verify: true,
+				Config: []config.Domain{{Name: "patient", ResourceTypes: []string{"Patient"}}},
+			}}),
+			concurrency: 1,
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, bson.D{{Key: "name", Value: "Patient"}}),
+		)
+
+		// act
+		_, err := p.Run(context.Background())
+
+		assert.EqualError(mt, err, "missing gPAS domains: test-patient")
+	})
+}
+
+func TestRunInterrupted(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("interrupted", func(mt *mtest.T) {
+
+		provider := &MongoFhirProvider{
+			Client:      mt.Client,
+			Source:      mt.DB,
+			Destination: mt.DB,
+			name:        "MongoDB Test Provider",
+		}
+
+		statusFile := filepath.Join(mt.TempDir(), "status.json")
+		p := &Processor{
+			provider:      provider,
+			pseudonymizer: NewClient(config.Pseudonymizer{}),
+			project:       "test",
+			gpas:          ttp.NewGpasClient(config.Gpas{}),
 			concurrency:   1,
+			statusFile:    statusFile,
+		}
+
+		// already cancelled
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		_, err := p.Run(ctx)
+
+		assert.ErrorIs(mt, err, context.Canceled)
+
+		data, _ := os.ReadFile(statusFile)
+		var status RunStatus
+		_ = json.Unmarshal(data, &status)
+		assert.Equal(mt, "test", status.Project)
+		assert.Equal(mt, StateInterrupted, status.State)
+	})
+}
+
+func TestRunGracePeriod(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("grace period", func(mt *mtest.T) {
+
+		provider := &MongoFhirProvider{
+			Client:      mt.Client,
+			Source:      mt.DB,
+			Destination: mt.DB,
+			name:        "MongoDB Test Provider",
 		}
 
-		// test resources
-		pat := MongoResource{
-			Id:         primitive.ObjectID{},
-			Fhir:       bson.M{"resourceType": "Patient"},
-			Collection: nil,
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// slow pseudonymizer, shut down while the request is in flight
+		s := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			cancel()
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}))
+		defer s.Close()
+
+		p := &Processor{
+			provider:      provider,
+			pseudonymizer: NewClient(config.Pseudonymizer{Url: s.URL}),
+			project:       "test",
+			gpas:          ttp.NewGpasClient(config.Gpas{}),
+			concurrency:   1,
+			gracePeriod:   50 * time.Millisecond,
 		}
-		obs := MongoResource{
-			Id:         primitive.ObjectID{},
-			Fhir:       bson.M{"resourceType": "Observation"},
-			Collection: nil,
+
+		collNames := bson.D{{Key: "name", Value: "Patient"}}
+		pat := toDoc(MongoResource{Id: primitive.NewObjectID(), Fhir: bson.M{"resourceType": "Patient"}})
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames),
+			mtest.CreateCursorResponse(0, "test.Patient", mtest.FirstBatch, pat),
+		)
+
+		// act
+		start := time.Now()
+		result, err := p.Run(ctx)
+
+		// the request in flight is cancelled after the grace period
+		assert.ErrorIs(mt, err, context.Canceled)
+		assert.Less(mt, time.Since(start), 2*time.Second)
+		assert.Empty(mt, result.count)
+	})
+}
+
+func TestRunPseudonymizerUnavailable(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("unavailable", func(mt *mtest.T) {
+
+		provider := &MongoFhirProvider{
+			Client:      mt.Client,
+			Source:      mt.DB,
+			Destination: mt.DB,
+			name:        "MongoDB Test Provider",
 		}
 
-		collNames := []bson.D{{{Key: "name", Value: "Patient"}}, {{Key: "name", Value: "Observation"}}}
+		s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+			res.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer s.Close()
 
-		// expect one Patient and one Observation in results
-		expResultCount := map[string]int{"Patient": 1, "Observation": 1}
+		pseudonymizer := NewClient(config.Pseudonymizer{Url: s.URL, CircuitBreaker: config.CircuitBreaker{Threshold: 1}})
+		pseudonymizer.breaker.timeout = 20 * time.Millisecond
 
-		// setup mocks
-		// mongodb
+		p := &Processor{
+			provider:      provider,
+			pseudonymizer: pseudonymizer,
+			project:       "test",
+			gpas:          ttp.NewGpasClient(config.Gpas{}),
+			concurrency:   1,
+		}
+
+		collNames := bson.D{{Key: "name", Value: "Patient"}}
+		pat := toDoc(MongoResource{Id: primitive.NewObjectID(), Fhir: bson.M{"resourceType": "Patient"}})
 		mt.AddMockResponses(
-			// list collections and read data
-			mtest.CreateCursorResponse(1, "test.$cmd.listCollections", mtest.FirstBatch, collNames...),
-			mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
-			mtest.CreateCursorResponse(2, "test.Patient", mtest.FirstBatch, toDoc(pat)),
-			mtest.CreateSuccessResponse(),
-			mtest.CreateCursorResponse(3, "test.Observation", mtest.FirstBatch, toDoc(obs)),
+			mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames),
+			mtest.CreateCursorResponse(0, "test.Patient", mtest.FirstBatch, pat, pat),
+		)
+
+		// act
+		_, err := p.Run(context.Background())
 
-			// save data back
-			mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse(),
+		assert.ErrorIs(mt, err, ErrPseudonymizerUnavailable)
+		assert.NotErrorIs(mt, err, context.Canceled)
+	})
+}
+
+func TestRunPreflightFailed(t *testing.T) {
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("preflight", func(mt *mtest.T) {
+
+		provider := &MongoFhirProvider{
+			Client:      mt.Client,
+			Source:      mt.DB,
+			Destination: mt.DB,
+			name:        "MongoDB Test Provider",
+		}
+
+		s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+			res.WriteHeader(http.StatusNotFound)
+		}))
+		defer s.Close()
+
+		p := &Processor{
+			provider:      provider,
+			pseudonymizer: NewClient(config.Pseudonymizer{Url: s.URL}),
+			project:       "test",
+			gpas:          ttp.NewGpasClient(config.Gpas{Url: s.URL}),
+			concurrency:   1,
+			preflight:     true,
+		}
+
+		// source database doesn't exist
+		mt.AddMockResponses(
 			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(bson.E{Key: "databases", Value: bson.A{}}),
 		)
 
-		// rest client (pseudonymization)
-		httpmock.ActivateNonDefault(p.pseudonymizer.rest.GetClient())
-		httpmock.RegisterResponder("POST", "/$de-identify", func(req *http.Request) (*http.Response, error) {
-			body, err := io.ReadAll(req.Body)
-			if err != nil {
-				return httpmock.NewStringResponse(400, ""), nil
-			}
-			// just return the request body
-			// it's a Parameters resource, but that doesn't matter here
-			return httpmock.NewBytesResponse(200, body), nil
-		})
-
 		// act
-		result, err := p.Run()
+		_, err := p.Run(context.Background())
 
-		assert.Nil(t, err)
-		assert.Equal(t, expResultCount, result.count)
+		// all problems are reported
+		assert.EqualError(mt, err, fmt.Sprintf(`preflight check failed: source database %s does not exist
+FHIR pseudonymizer metadata request returned 404 Not Found
+gPAS request to %s returned 404 Not Found`, mt.DB.Name(), s.URL))
 	})
 }
 
-func toDoc(v interface{}) (doc bson.D) {
-	data, _ := bson.Marshal(v)
+func TestProcessFailedProgress(t *testing.T) {
 
-	_ = bson.Unmarshal(data, &doc)
-	return
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("failed", func(mt *mtest.T) {
+
+		provider := &MongoFhirProvider{
+			Client:      mt.Client,
+			Source:      mt.DB,
+			Destination: mt.DB,
+			name:        "MongoDB Test Provider",
+		}
+
+		s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+			res.WriteHeader(http.StatusBadRequest)
+		}))
+		defer s.Close()
+
+		p := &Processor{
+			provider:      provider,
+			pseudonymizer: NewClient(config.Pseudonymizer{Url: s.URL}),
+			project:       "test",
+			gpas:          ttp.NewGpasClient(config.Gpas{}),
+			concurrency:   1,
+			retries:       NewRetries(),
+		}
+		progress := NewProgress("test", map[string]int64{mt.Coll.Name(): 2})
+		r := MongoResource{Id: primitive.NewObjectID(), Fhir: bson.M{"resourceType": "Patient"}, Collection: mt.Coll}
+
+		// pseudonymize fails
+		err := p.process(context.Background(), r, make(chan pseudonymized, 1), progress)
+		assert.Error(mt, err)
+
+		// write fails
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11000, Message: "duplicate key"}))
+		results := make(chan string, 1)
+		p.write(context.Background(), pseudonymized{resource: r, span: trace.SpanFromContext(context.Background())}, results, progress)
+		assert.Empty(mt, results)
+
+		// both resources are done
+		overall, _ := progress.Status()
+		assert.Equal(mt, int64(2), overall.Done)
+	})
 }
 
-func TestNewProcessor(t *testing.T) {
-	p, _ := NewProcessor(&config.AppConfig{Fhir: config.Fhir{
-		Pseudonymizer: config.Pseudonymizer{},
-		Provider: config.Provider{
-			MongoDb: config.MongoDb{
-				Connection: "mongodb://localhost",
-			},
-		},
-	}}, "test")
+func TestRunFailedResourceContinues(t *testing.T) {
 
-	assert.Equal(t, 1, p.concurrency)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("failed resource", func(mt *mtest.T) {
+		dest := mtest.New(mt.T, mtest.NewOptions().ClientType(mtest.Mock))
+		dest.Run("destination", func(dest *mtest.T) {
+
+			provider := &MongoFhirProvider{
+				Client:      mt.Client,
+				Source:      mt.DB,
+				Destination: dest.DB,
+				name:        "MongoDB Test Provider",
+			}
+
+			// the first resource fails, the second one is pseudonymized
+			var requests atomic.Int32
+			s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if requests.Add(1) == 1 {
+					res.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				_, _ = res.Write(body)
+			}))
+			defer s.Close()
+
+			p := &Processor{
+				provider:      provider,
+				pseudonymizer: NewClient(config.Pseudonymizer{Url: s.URL}),
+				project:       "test",
+				gpas:          ttp.NewGpasClient(config.Gpas{}),
+				concurrency:   1,
+			}
+
+			collNames := bson.D{{Key: "name", Value: "Patient"}}
+			pat1 := toDoc(MongoResource{Id: primitive.NewObjectID(), Fhir: bson.M{"resourceType": "Patient"}})
+			pat2 := toDoc(MongoResource{Id: primitive.NewObjectID(), Fhir: bson.M{"resourceType": "Patient"}})
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+				mtest.CreateCursorResponse(0, "test.$cmd.listCollections", mtest.FirstBatch, collNames),
+				mtest.CreateCursorResponse(0, "test.Patient", mtest.FirstBatch, pat1, pat2),
+			)
+			dest.AddMockResponses(mtest.CreateSuccessResponse())
+
+			// act
+			result, err := p.Run(context.Background())
+
+			// the single worker continued after the failed resource
+			assert.NoError(mt, err)
+			assert.Equal(mt, int32(2), requests.Load())
+			assert.Equal(mt, map[string]int{"Patient": 1}, result.count)
+		})
+	})
 }
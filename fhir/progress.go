@@ -0,0 +1,183 @@
+package fhir
+
+import (
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// barRefresh is the refresh interval of the progress bar
+const barRefresh = 500 * time.Millisecond
+
+// Progress tracks the number of processed resources per collection of a
+// project
+type Progress struct {
+	mu      sync.Mutex
+	project string
+	start   time.Time
+	started map[string]time.Time
+	total   map[string]int64
+	done    map[string]int64
+}
+
+type ProgressStatus struct {
+	Name  string
+	Done  int64
+	Total int64
+	// Rate of processed resources per second
+	Rate float64
+	// Eta is negative if unknown
+	Eta time.Duration
+}
+
+func NewProgress(project string, total map[string]int64) *Progress {
+	if total == nil {
+		total = make(map[string]int64)
+	}
+	return &Progress{
+		project: project,
+		start:   time.Now(),
+		started: make(map[string]time.Time),
+		total:   total,
+		done:    make(map[string]int64),
+	}
+}
+
+// Add counts a processed resource of a collection
+func (p *Progress) Add(collection string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exists := p.started[collection]; !exists {
+		p.started[collection] = time.Now()
+	}
+	p.done[collection]++
+}
+
+// Status returns the overall progress and the progress of all collections
+// currently in progress
+func (p *Progress) Status() (ProgressStatus, []ProgressStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var done, total int64
+	var collections []ProgressStatus
+	for c, t := range p.total {
+		total += t
+		d := p.done[c]
+		done += d
+		if d > 0 && d < t {
+			collections = append(collections, newProgressStatus(c, d, t, now.Sub(p.started[c])))
+		}
+	}
+	slices.SortFunc(collections, func(a, b ProgressStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	// collections without count
+	for c, d := range p.done {
+		if _, exists := p.total[c]; !exists {
+			done += d
+		}
+	}
+
+	return newProgressStatus("overall", done, total, now.Sub(p.start)), collections
+}
+
+func newProgressStatus(name string, done, total int64, elapsed time.Duration) ProgressStatus {
+	s := ProgressStatus{Name: name, Done: done, Total: total, Eta: -1}
+	if elapsed > 0 {
+		s.Rate = float64(done) / elapsed.Seconds()
+	}
+
+	switch {
+	case total > 0 && done >= total:
+		s.Eta = 0
+	case total > 0 && s.Rate > 0:
+		s.Eta = time.Duration(float64(total-done) / s.Rate * float64(time.Second))
+	}
+
+	return s
+}
+
+// Percent of processed resources, capped at 100
+func (s ProgressStatus) Percent() float64 {
+	if s.Total <= 0 {
+		return 0
+	}
+	return min(100, float64(s.Done)/float64(s.Total)*100)
+}
+
+func (s ProgressStatus) eta() string {
+	if s.Eta < 0 {
+		return "unknown"
+	}
+	return s.Eta.Round(time.Second).String()
+}
+
+// Report periodically reports the progress until done is closed. When
+// attached to a terminal and bar is set, the progress is rendered as a
+// progress bar, otherwise it's logged every interval.
+func (p *Progress) Report(interval time.Duration, bar bool, done <-chan struct{}) {
+	if bar && term.IsTerminal(int(os.Stderr.Fd())) {
+		p.render(os.Stderr, done)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p.log()
+		}
+	}
+}
+
+func (p *Progress) log() {
+	overall, collections := p.Status()
+	for _, s := range collections {
+		slog.Info("Collection progress", "project", p.project, "collection", s.Name, "done", s.Done, "total", s.Total,
+			"percent", fmt.Sprintf("%.1f", s.Percent()), "rate", fmt.Sprintf("%.1f/s", s.Rate), "eta", s.eta())
+	}
+	slog.Info("Overall progress", "project", p.project, "done", overall.Done, "total", overall.Total,
+		"percent", fmt.Sprintf("%.1f", overall.Percent()), "rate", fmt.Sprintf("%.1f/s", overall.Rate), "eta", overall.eta())
+}
+
+func (p *Progress) render(w io.Writer, done <-chan struct{}) {
+	ticker := time.NewTicker(barRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			overall, _ := p.Status()
+			_, _ = fmt.Fprintf(w, "\r%s\n", progressBar(overall, 30))
+			return
+		case <-ticker.C:
+			overall, _ := p.Status()
+			_, _ = fmt.Fprintf(w, "\r%s", progressBar(overall, 30))
+		}
+	}
+}
+
+// progressBar formats a status as a progress bar of the given width
+func progressBar(s ProgressStatus, width int) string {
+	filled := int(s.Percent() / 100 * float64(width))
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+
+	return fmt.Sprintf("[%s] %5.1f%% %d/%d %.1f/s ETA %s", bar, s.Percent(), s.Done, s.Total, s.Rate, s.eta())
+}
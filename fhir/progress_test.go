@@ -0,0 +1,71 @@
+package fhir
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestProgressStatus(t *testing.T) {
+
+	p := NewProgress("test", map[string]int64{"Patient": 4, "Observation": 10})
+	p.start = time.Now().Add(-2 * time.Second)
+
+	for i := 0; i < 4; i++ {
+		p.Add("Patient")
+	}
+	p.Add("Observation")
+	p.started["Observation"] = time.Now().Add(-time.Second)
+
+	overall, collections := p.Status()
+
+	assert.Equal(t, int64(5), overall.Done)
+	assert.Equal(t, int64(14), overall.Total)
+	assert.InDelta(t, 2.5, overall.Rate, 0.1)
+	assert.InDelta(t, (3600 * time.Millisecond).Seconds(), overall.Eta.Seconds(), 0.2)
+
+	// completed collections are omitted
+	assert.Len(t, collections, 1)
+	assert.Equal(t, "Observation", collections[0].Name)
+	assert.Equal(t, int64(1), collections[0].Done)
+	assert.InDelta(t, (9 * time.Second).Seconds(), collections[0].Eta.Seconds(), 0.2)
+}
+
+func TestProgressStatusUnknownTotal(t *testing.T) {
+
+	p := NewProgress("test", nil)
+	p.Add("Patient")
+
+	overall, collections := p.Status()
+
+	assert.Equal(t, int64(1), overall.Done)
+	assert.Equal(t, time.Duration(-1), overall.Eta)
+	assert.Equal(t, "unknown", overall.eta())
+	assert.Empty(t, collections)
+}
+
+func TestProgressBar(t *testing.T) {
+
+	s := ProgressStatus{Done: 5, Total: 10, Rate: 2.5, Eta: 2 * time.Second}
+
+	assert.Equal(t, "[=====>    ]  50.0% 5/10 2.5/s ETA 2s", progressBar(s, 10))
+
+	s = ProgressStatus{Done: 10, Total: 10, Rate: 2.5}
+	assert.Equal(t, "[==========] 100.0% 10/10 2.5/s ETA 0s", progressBar(s, 10))
+}
+
+func TestProgressLog(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	p := NewProgress("study-a", map[string]int64{"Patient": 2})
+	p.Add("Patient")
+
+	p.log()
+
+	assert.Contains(t, buf.String(), `msg="Collection progress" project=study-a collection=Patient`)
+	assert.Contains(t, buf.String(), `msg="Overall progress" project=study-a`)
+}
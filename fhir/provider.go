@@ -2,12 +2,19 @@ package fhir
 
 import (
 	"context"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"log/slog"
 	"pseudonymous/config"
+	"pseudonymous/metrics"
+	"pseudonymous/tracing"
+	"slices"
+	"sync"
 	"time"
 )
 
@@ -19,117 +26,355 @@ type MongoResource struct {
 
 type Provider interface {
 	Name() string
-	Read(chan<- MongoResource) error
-	Write(resource MongoResource) error
+	ResourceTypes(ctx context.Context) ([]string, error)
+	Count(ctx context.Context) (map[string]int64, error)
+	Check(ctx context.Context) error
+	Read(ctx context.Context, res chan<- MongoResource) error
+	Write(ctx context.Context, resource MongoResource) error
 	Close() error
 }
 
+const (
+	defaultConnectTimeout = 10 * time.Second
+	// timeout for closing cursors and connections
+	closeTimeout = 10 * time.Second
+)
+
 type MongoFhirProvider struct {
-	Client      *mongo.Client
-	Context     context.Context
-	Source      *mongo.Database
-	Destination *mongo.Database
-	name        string
-	batchSize   int
+	Client          *mongo.Client
+	Source          *mongo.Database
+	Destination     *mongo.Database
+	name            string
+	batchSize       int
+	cursorTimeout   time.Duration
+	noCursorTimeout bool
+	readers         int
+	partitions      int
+	// shared clients are disconnected by their owner
+	shared bool
 }
 
 func (p *MongoFhirProvider) Close() error {
+	if p.shared {
+		return nil
+	}
 	return p.Disconnect()
 }
 
-func NewProvider(config config.Provider, database string) *MongoFhirProvider {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func NewProvider(cfg config.Provider, database string) *MongoFhirProvider {
+	client, err := connect(cfg.MongoDb)
+	if err != nil {
+		return nil
+	}
+
+	return newMongoProvider(client, cfg.MongoDb, database)
+}
+
+// connect creates the client of the MongoDB server
+func connect(cfg config.MongoDb) (*mongo.Client, error) {
+	connectTimeout := time.Duration(cfg.ConnectTimeout) * time.Second
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	// the context is only used to connect
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
 	defer cancel()
 
-	connection := config.MongoDb.Connection
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(connection))
+	connection := cfg.Connection
+	opts := options.Client().
+		ApplyURI(connection).
+		SetConnectTimeout(connectTimeout).
+		SetServerSelectionTimeout(connectTimeout)
+	if cfg.Tls != nil {
+		tlsConfig, err := cfg.Tls.ClientConfig()
+		if err != nil {
+			slog.Error("Failed to configure TLS for mongo", "error", err.Error())
+			return nil, err
+		}
+		opts = opts.SetTLSConfig(tlsConfig)
+	}
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		slog.Error("Failed to connect to mongo", "connection", connection, "error", err.Error())
-		return nil
+		slog.Error("Failed to connect to mongo", "connection", config.RedactUrl(connection), "error", err.Error())
+		return nil, err
 	}
 
+	return client, nil
+}
+
+// newMongoProvider creates the provider of a project's databases
+func newMongoProvider(client *mongo.Client, cfg config.MongoDb, database string) *MongoFhirProvider {
 	// prefix by convention
 	source := client.Database("idat_fhir_" + database)
 	dest := client.Database("psn_fhir_" + database)
 
 	return &MongoFhirProvider{
-		name:        "MongoFhirProvider",
-		Client:      client,
-		Context:     ctx,
-		Source:      source,
-		Destination: dest,
-		batchSize:   config.MongoDb.BatchSize,
+		name:            "MongoFhirProvider",
+		Client:          client,
+		Source:          source,
+		Destination:     dest,
+		batchSize:       cfg.BatchSize,
+		cursorTimeout:   time.Duration(cfg.CursorTimeout) * time.Second,
+		noCursorTimeout: cfg.NoCursorTimeout,
+		readers:         cfg.Readers,
+		partitions:      cfg.Partitions,
 	}
 }
 
 func (p *MongoFhirProvider) Disconnect() error {
-	return p.Client.Disconnect(p.Context)
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	return p.Client.Disconnect(ctx)
+}
+
+// ResourceTypes returns the resource types of the source database, i.e. its
+// collection names
+func (p *MongoFhirProvider) ResourceTypes(ctx context.Context) ([]string, error) {
+	return p.Source.ListCollectionNames(ctx, bson.M{})
+}
+
+// Count returns the estimated number of resources per collection of the
+// source database
+func (p *MongoFhirProvider) Count(ctx context.Context) (map[string]int64, error) {
+	collectionNames, err := p.ResourceTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(collectionNames))
+	for _, colName := range collectionNames {
+		count, err := p.Source.Collection(colName).EstimatedDocumentCount(ctx)
+		if err != nil {
+			slog.Error("Failed to count documents of database collection", "database", p.Source.Name(), "collection", colName, "error", err.Error())
+			return nil, err
+		}
+		counts[colName] = count
+	}
+
+	return counts, nil
+}
+
+// Check pings the server and verifies the source database exists and has
+// resources. Empty collections are logged.
+func (p *MongoFhirProvider) Check(ctx context.Context) error {
+	if err := p.Client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+
+	names, err := p.Client.ListDatabaseNames(ctx, bson.M{"name": p.Source.Name()})
+	if err != nil {
+		return fmt.Errorf("failed to list databases: %w", err)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("source database %s does not exist", p.Source.Name())
+	}
+
+	counts, err := p.Count(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count resources of source database %s: %w", p.Source.Name(), err)
+	}
+	var empty []string
+	for collection, count := range counts {
+		if count == 0 {
+			empty = append(empty, collection)
+		}
+	}
+	if len(empty) == len(counts) {
+		return fmt.Errorf("source database %s has no resources", p.Source.Name())
+	}
+	if len(empty) > 0 {
+		slices.Sort(empty)
+		slog.Warn("Source database has empty collections", "database", p.Source.Name(), "collections", empty)
+	}
+
+	return nil
+}
+
+func (p *MongoFhirProvider) Read(ctx context.Context, res chan<- MongoResource) error {
+	return p.read(ctx, p.Source, res)
+}
+
+// ReadPseudonymized reads all resources from the destination database
+func (p *MongoFhirProvider) ReadPseudonymized(ctx context.Context, res chan<- MongoResource) error {
+	return p.read(ctx, p.Destination, res)
 }
 
-func (p *MongoFhirProvider) Read(res chan<- MongoResource) error {
+// read sends all resources of a database to res. Each collection is split
+// into partitions, which are read in parallel by the configured number of
+// readers, each with its own cursor. Fetching a batch from the database is
+// traced with its own span.
+func (p *MongoFhirProvider) read(ctx context.Context, db *mongo.Database, res chan<- MongoResource) error {
 	// get collections
-	collectionNames, err := p.Source.ListCollectionNames(context.Background(), bson.M{})
+	collectionNames, err := db.ListCollectionNames(ctx, bson.M{})
 	if err != nil {
-		slog.Error("Failed to list collections from source database", "database", p.Source.Name(), "error", err.Error())
+		slog.Error("Failed to list collections from database", "database", db.Name(), "error", err.Error())
 		return err
 	}
 
 	if len(collectionNames) == 0 {
-		slog.Error("No collections found in source database", "database", p.Source.Name())
+		slog.Error("No collections found in database", "database", db.Name())
 		return err
 	}
 
-	ctx := context.Background()
-	batchSize := int32(p.batchSize)
-	slog.Info("Fetching data from source database", "database", p.Source.Name(), "batchSize", batchSize)
+	readers := max(1, p.readers)
+	slog.Info("Fetching data from database", "database", db.Name(), "batchSize", p.batchSize,
+		"readers", readers, "partitions", max(1, p.partitions))
+
+	// the first error stops all readers
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	parts := make(chan partition)
+	wg := new(sync.WaitGroup)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				count, err := p.readPartition(ctx, part, res)
+				if err != nil {
+					cancel(err)
+					return
+				}
+				slog.Info("Successfully read resources from database collection", "database", db.Name(),
+					"collection", part.collection.Name(), "partition", part.filter, "count", count)
+			}
+		}()
+	}
 
 	for _, colName := range collectionNames {
+		collection := db.Collection(colName)
+		colParts, err := partitions(ctx, collection, p.partitions)
+		if err != nil {
+			slog.Error("Failed to partition database collection", "database", db.Name(), "collection", colName, "error", err.Error())
+			cancel(err)
+			break
+		}
+		if !sendPartitions(ctx, parts, colParts) {
+			break
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	return context.Cause(ctx)
+}
 
-		// get resources
-		var cur *mongo.Cursor
-		collection := p.Source.Collection(colName)
-		cur, err = collection.Find(ctx, bson.M{}, options.Find().SetBatchSize(batchSize))
+// sendPartitions hands the partitions over to the readers until ctx is done
+func sendPartitions(ctx context.Context, parts chan<- partition, colParts []partition) bool {
+	for _, part := range colParts {
+		select {
+		case parts <- part:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// readPartition sends all resources of a partition to res. The cursor is
+// closed when done.
+func (p *MongoFhirProvider) readPartition(ctx context.Context, part partition, res chan<- MongoResource) (int, error) {
+	collection := part.collection
+	database := collection.Database().Name()
+	colName := collection.Name()
+
+	// get resources
+	opts := options.Find().
+		SetBatchSize(int32(p.batchSize)).
+		SetNoCursorTimeout(p.noCursorTimeout)
+
+	batchCtx, span, cancel := p.startBatch(ctx, database, colName)
+	cur, err := collection.Find(batchCtx, part.filter, opts)
+	cancel()
+	tracing.End(span, err)
+	if err != nil {
+		slog.Error("Failed to create cursor on database collection", "database", database, "collection", colName, "error", err.Error())
+		return 0, err
+	}
+	defer closeCursor(cur)
+
+	count := 0
+	for p.next(ctx, cur, database, colName) {
+		var result MongoResource
+		err = cur.Decode(&result)
 		if err != nil {
-			slog.Error("Failed to create cursor on database collection", "database", p.Source.Name(), "collection", colName, "error", err.Error())
-			return err
+			slog.Error("Failed to read next batch", "database", database, "collection", colName, "error", err.Error())
+			return count, err
 		}
-		defer closeCursor(ctx, cur)
-
-		count := 0
-		for cur.Next(ctx) {
-			var result MongoResource
-			err = cur.Decode(&result)
-			if err != nil {
-				slog.Error("Failed to read next batch", "database", p.Source.Name(), "collection", colName, "error", err.Error())
-				return err
-			}
-			count++
-			result.Collection = collection
-			res <- result
+		count++
+		metrics.ResourcesRead.WithLabelValues(colName).Inc()
+		result.Collection = collection
+
+		if err = enqueue(ctx, "read", res, result); err != nil {
+			return count, err
 		}
+	}
+	if err = cur.Err(); err != nil {
+		slog.Error("Failed to read next batch", "database", database, "collection", colName, "error", err.Error())
+		return count, err
+	}
 
-		slog.Info("Successfully read resources from database collection", "database", p.Source.Name(), "collection", colName, "count", count)
+	return count, nil
+}
 
+// next advances the cursor, tracing fetches of the next batch. Fetching a
+// batch is limited by the cursor timeout, if set.
+func (p *MongoFhirProvider) next(ctx context.Context, cur *mongo.Cursor, database, collection string) bool {
+	if cur.RemainingBatchLength() > 0 {
+		return cur.Next(ctx)
 	}
 
-	return nil
+	batchCtx, span, cancel := p.startBatch(ctx, database, collection)
+	hasNext := cur.Next(batchCtx)
+	cancel()
+	tracing.End(span, cur.Err())
 
+	return hasNext
 }
 
-func closeCursor(ctx context.Context, cur *mongo.Cursor) {
+// startBatch starts the span of fetching a batch and applies the cursor
+// timeout, if set
+func (p *MongoFhirProvider) startBatch(ctx context.Context, database, collection string) (context.Context, trace.Span, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if p.cursorTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.cursorTimeout)
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "read batch", trace.WithAttributes(
+		attribute.String("db.namespace", database),
+		attribute.String("db.collection.name", collection),
+	))
+	return ctx, span, cancel
+}
+
+// closeCursor closes the cursor independently of the read context, which may
+// already be cancelled
+func closeCursor(cur *mongo.Cursor) {
 	if cur != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+
 		_ = cur.Close(ctx)
 	}
 }
 
-func (p *MongoFhirProvider) Write(res MongoResource) error {
+func (p *MongoFhirProvider) Write(ctx context.Context, res MongoResource) error {
 
 	coll := p.Destination.Collection(res.Collection.Name())
 	opts := options.Update().SetUpsert(true)
 	update := bson.D{{Key: "$set", Value: bson.D{{Key: "fhir", Value: res.Fhir}}}}
 
-	_, err := coll.UpdateByID(context.Background(), res.Id, update, opts)
+	ctx, span := tracing.Tracer().Start(ctx, "write", trace.WithAttributes(
+		attribute.String("db.namespace", p.Destination.Name()),
+		attribute.String("db.collection.name", coll.Name()),
+	))
+	_, err := coll.UpdateByID(ctx, res.Id, update, opts)
+	tracing.End(span, err)
 	if err == nil {
+		metrics.ResourcesWritten.WithLabelValues(coll.Name()).Inc()
 		slog.Debug("Document written", "_id", res.Id.Hex())
 	}
 
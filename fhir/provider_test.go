@@ -0,0 +1,110 @@
+package fhir
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+	"pseudonymous/config"
+	"testing"
+	"time"
+)
+
+func TestNewProvider(t *testing.T) {
+	p := NewProvider(config.Provider{MongoDb: config.MongoDb{
+		Connection:      "mongodb://localhost",
+		BatchSize:       100,
+		ConnectTimeout:  1,
+		CursorTimeout:   30,
+		NoCursorTimeout: true,
+	}}, "test")
+
+	assert.NotNil(t, p)
+	assert.Equal(t, "idat_fhir_test", p.Source.Name())
+	assert.Equal(t, "psn_fhir_test", p.Destination.Name())
+	assert.Equal(t, 30*time.Second, p.cursorTimeout)
+	assert.True(t, p.noCursorTimeout)
+
+	// closing must not depend on the (expired) connect context
+	assert.NoError(t, p.Close())
+}
+
+func TestReadCancelled(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("cancelled", func(mt *mtest.T) {
+		p := &MongoFhirProvider{Client: mt.Client, Source: mt.DB, cursorTimeout: time.Second}
+
+		ns := mt.DB.Name() + ".Patient"
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, ns, mtest.FirstBatch,
+				bson.D{{Key: "_id", Value: primitive.NewObjectID()}}),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// nobody receives, so the read has to stop on cancellation
+		_, err := p.readPartition(ctx, partition{collection: mt.DB.Collection("Patient"), filter: bson.M{}}, make(chan MongoResource))
+		assert.ErrorIs(mt, err, context.Canceled)
+	})
+}
+
+func TestNewProviderInvalidTls(t *testing.T) {
+	p := NewProvider(config.Provider{MongoDb: config.MongoDb{
+		Connection: "mongodb://localhost",
+		Tls:        &config.Tls{MinVersion: "1.0"},
+	}}, "test")
+
+	assert.Nil(t, p)
+}
+
+func TestCheck(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	collNames := []bson.D{{{Key: "name", Value: "Patient"}}, {{Key: "name", Value: "Observation"}}}
+	databases := func(names ...string) bson.E {
+		dbs := bson.A{}
+		for _, n := range names {
+			dbs = append(dbs, bson.D{{Key: "name", Value: n}})
+		}
+		return bson.E{Key: "databases", Value: dbs}
+	}
+
+	mt.Run("success", func(mt *mtest.T) {
+		p := &MongoFhirProvider{Client: mt.Client, Source: mt.DB}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(databases(mt.DB.Name())),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".$cmd.listCollections", mtest.FirstBatch, collNames...),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+		)
+
+		assert.NoError(mt, p.Check(context.Background()))
+	})
+
+	mt.Run("missing database", func(mt *mtest.T) {
+		p := &MongoFhirProvider{Client: mt.Client, Source: mt.DB}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(databases()),
+		)
+
+		assert.EqualError(mt, p.Check(context.Background()), "source database "+mt.DB.Name()+" does not exist")
+	})
+
+	mt.Run("no resources", func(mt *mtest.T) {
+		p := &MongoFhirProvider{Client: mt.Client, Source: mt.DB}
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(),
+			mtest.CreateSuccessResponse(databases(mt.DB.Name())),
+			mtest.CreateCursorResponse(0, mt.DB.Name()+".$cmd.listCollections", mtest.FirstBatch, collNames...),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+		)
+
+		assert.EqualError(mt, p.Check(context.Background()), "source database "+mt.DB.Name()+" has no resources")
+	})
+}
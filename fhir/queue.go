@@ -0,0 +1,50 @@
+package fhir
+
+import (
+	"context"
+	"pseudonymous/metrics"
+	"time"
+)
+
+// queueSampling is the interval for sampling the length of the queues
+const queueSampling = time.Second
+
+// enqueue sends v to ch until ctx is done. The time spent waiting for a full
+// queue is recorded as backpressure on the sending stage.
+func enqueue[T any](ctx context.Context, stage string, ch chan<- T, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.StageBlocked.WithLabelValues(stage).Add(time.Since(start).Seconds())
+	}()
+
+	select {
+	case ch <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observeQueues samples the length of the queues until done is closed
+func observeQueues(queues map[string]func() int, done <-chan struct{}) {
+	ticker := time.NewTicker(queueSampling)
+	defer ticker.Stop()
+
+	for {
+		for name, length := range queues {
+			metrics.QueueLength.WithLabelValues(name).Set(float64(length()))
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package fhir
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"pseudonymous/metrics"
+	"testing"
+	"time"
+)
+
+func TestEnqueue(t *testing.T) {
+	ch := make(chan int, 1)
+
+	err := enqueue(context.Background(), "test", ch, 1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, <-ch)
+	assert.Zero(t, testutil.ToFloat64(metrics.StageBlocked.WithLabelValues("test")))
+}
+
+func TestEnqueueBlocked(t *testing.T) {
+	ch := make(chan int)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		<-ch
+	}()
+
+	err := enqueue(context.Background(), "blocked", ch, 1)
+
+	assert.NoError(t, err)
+	assert.Greater(t, testutil.ToFloat64(metrics.StageBlocked.WithLabelValues("blocked")), 0.0)
+}
+
+func TestEnqueueCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := enqueue(ctx, "cancelled", make(chan int), 1)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,46 @@
+package fhir
+
+import (
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"pseudonymous/config"
+	"strconv"
+	"time"
+)
+
+// retryable is true for connection errors and responses indicating a
+// temporary failure: 408, 429 and 5xx. Other client errors are not retried.
+func retryable(resp *resty.Response, err error) bool {
+	if err != nil {
+		return config.TransportError(resp, err)
+	}
+
+	code := resp.StatusCode()
+	return code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests ||
+		code >= http.StatusInternalServerError
+}
+
+// retryAfter returns the wait time requested by the Retry-After header. Without
+// it, resty falls back to exponential backoff with jitter. The wait time is
+// bounded by the configured wait and max wait in both cases.
+func retryAfter(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+	return parseRetryAfter(resp.Header().Get("Retry-After"), time.Now()), nil
+}
+
+// parseRetryAfter parses the Retry-After value in seconds or as HTTP date. It
+// returns 0 if not set or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(0, t.Sub(now))
+	}
+
+	return 0
+}
@@ -0,0 +1,139 @@
+package fhir
+
+import (
+	"context"
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pseudonymous/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnprocessableEntity, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+
+	for _, c := range cases {
+		resp := &resty.Response{RawResponse: &http.Response{StatusCode: c.status}}
+		assert.Equal(t, c.retryable, retryable(resp, nil), "status %d", c.status)
+	}
+
+	req := resty.New().R()
+	connErr := &url.Error{Op: "Post", URL: "http://localhost", Err: errors.New("connection refused")}
+	assert.True(t, retryable(&resty.Response{Request: req}, connErr))
+	assert.False(t, retryable(&resty.Response{Request: req}, errors.New("invalid request")))
+	// failed before the request was sent
+	assert.False(t, retryable(nil, connErr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, retryable(&resty.Response{Request: req.SetContext(ctx)}, connErr))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-3", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Wed, 01 Jan 2025 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Wed, 01 Jan 2025 11:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestSendRetries(t *testing.T) {
+	var attempts atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		if attempts.Add(1) < 3 {
+			res.Header().Set("Retry-After", "1")
+			res.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	// max wait bounds the requested wait time
+	client := NewClient(config.Pseudonymizer{Url: s.URL, Retry: config.Retry{Count: 3}})
+	client.rest.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(10 * time.Millisecond)
+
+	_, retries, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, retries)
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	var attempts atomic.Int32
+	var first time.Time
+	var wait time.Duration
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		if attempts.Add(1) == 1 {
+			first = time.Now()
+			res.Header().Set("Retry-After", "1")
+			res.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		wait = time.Since(first)
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	// the requested wait time is between wait and max wait
+	client := NewClient(config.Pseudonymizer{Url: s.URL, Retry: config.Retry{Count: 3}})
+	client.rest.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(5 * time.Second)
+
+	_, retries, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, retries)
+	assert.GreaterOrEqual(t, wait, time.Second)
+	assert.Less(t, wait, 2*time.Second)
+}
+
+func TestSendHookErrorNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	client := NewClient(config.Pseudonymizer{Url: "http://localhost", Retry: config.Retry{Count: 3}})
+	client.rest.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(time.Millisecond).
+		OnBeforeRequest(func(_ *resty.Client, _ *resty.Request) error {
+			calls.Add(1)
+			return errors.New("no token")
+		})
+
+	_, retries, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.EqualError(t, err, "no token")
+	assert.Equal(t, 0, retries)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestSendNotRetried(t *testing.T) {
+	var attempts atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		res.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	client := NewClient(config.Pseudonymizer{Url: s.URL, Retry: config.Retry{Count: 3}})
+
+	_, retries, err := client.Send(context.Background(), []byte(`{"resourceType":"Patient"}`), "test-")
+
+	assert.Error(t, err)
+	assert.Equal(t, 0, retries)
+	assert.Equal(t, int32(1), attempts.Load())
+}
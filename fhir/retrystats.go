@@ -0,0 +1,50 @@
+package fhir
+
+import "sync"
+
+// RetryStats are the retries of pseudonymizer requests of a collection
+type RetryStats struct {
+	// Resources with at least one retry
+	Resources int `json:"resources"`
+	Retries   int `json:"retries"`
+	// Max retries of a single resource
+	Max int `json:"max"`
+}
+
+// Retries collects the retries per collection
+type Retries struct {
+	mu    sync.Mutex
+	stats map[string]RetryStats
+}
+
+func NewRetries() *Retries {
+	return &Retries{stats: make(map[string]RetryStats)}
+}
+
+// Add counts the retries of a resource
+func (r *Retries) Add(collection string, retries int) {
+	if retries <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.stats[collection]
+	s.Resources++
+	s.Retries += retries
+	s.Max = max(s.Max, retries)
+	r.stats[collection] = s
+}
+
+// Stats returns a copy of the collected retries
+func (r *Retries) Stats() map[string]RetryStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := make(map[string]RetryStats, len(r.stats))
+	for c, s := range r.stats {
+		stats[c] = s
+	}
+	return stats
+}
@@ -0,0 +1,20 @@
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRetries(t *testing.T) {
+	r := NewRetries()
+
+	r.Add("Patient", 0)
+	r.Add("Patient", 2)
+	r.Add("Patient", 1)
+	r.Add("Observation", 3)
+
+	assert.Equal(t, map[string]RetryStats{
+		"Patient":     {Resources: 2, Retries: 3, Max: 2},
+		"Observation": {Resources: 1, Retries: 3, Max: 3},
+	}, r.Stats())
+}
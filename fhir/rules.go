@@ -0,0 +1,60 @@
+package fhir
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+)
+
+// AnonymizationConfig is the anonymization config of the FHIR Pseudonymizer.
+// Only the rules are of interest here.
+type AnonymizationConfig struct {
+	FhirPathRules []Rule `yaml:"fhirPathRules"`
+}
+
+type Rule struct {
+	Path   string `yaml:"path"`
+	Method string `yaml:"method"`
+	Domain string `yaml:"domain"`
+}
+
+var resourceTypePath = regexp.MustCompile(`^([A-Z][A-Za-z]+)(\.|$)`)
+
+// abstractTypes are base types of FHIR resources and elements, rules on them
+// apply to all resource types
+var abstractTypes = map[string]bool{
+	"Resource":        true,
+	"DomainResource":  true,
+	"BackboneElement": true,
+}
+
+// LoadRules reads the FHIR path rules from the pseudonymizer's anonymization
+// config file
+func LoadRules(file string) ([]Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var c AnonymizationConfig
+	if err = yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	return c.FhirPathRules, nil
+}
+
+// ResourceType returns the resource type the rule is restricted to or an empty
+// string if it applies to all resource types
+func (r Rule) ResourceType() string {
+	result := resourceTypePath.FindStringSubmatch(r.Path)
+	if result == nil || abstractTypes[result[1]] {
+		return ""
+	}
+	return result[1]
+}
+
+// Pseudonymizes is true for rules which request pseudonyms from a gPAS domain
+func (r Rule) Pseudonymizes() bool {
+	return r.Method == "pseudonymize" && r.Domain != ""
+}
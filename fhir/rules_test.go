@@ -0,0 +1,43 @@
+package fhir
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLoadRules(t *testing.T) {
+
+	rules, err := LoadRules("../dev/anonymization.yaml")
+
+	assert.Nil(t, err)
+	assert.Contains(t, rules, Rule{
+		Path:   "nodesByType('Identifier').where(type.coding.system='http://terminology.hl7.org/CodeSystem/v2-0203' and type.coding.code='MR').value",
+		Method: "pseudonymize",
+		Domain: "patient",
+	})
+}
+
+func TestLoadRulesMissingFile(t *testing.T) {
+
+	_, err := LoadRules("./missing.yaml")
+
+	assert.Error(t, err)
+}
+
+func TestRuleResourceType(t *testing.T) {
+
+	cases := map[string]string{
+		"Patient.id":                    "Patient",
+		"Encounter":                     "Encounter",
+		"nodesByType('HumanName')":      "",
+		"Bundle.entry.request.url":      "Bundle",
+		"descendants().ofType(Address)": "",
+		"Resource.meta.source":          "",
+		"DomainResource.text":           "",
+		"BackboneElement":               "",
+	}
+
+	for path, expected := range cases {
+		assert.Equal(t, expected, Rule{Path: path}.ResourceType(), path)
+	}
+}
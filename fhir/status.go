@@ -0,0 +1,162 @@
+package fhir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	StateCompleted   = "completed"
+	StateInterrupted = "interrupted"
+	StateFailed      = "failed"
+)
+
+// RunStatus is the final status of a run. Retries are the retries of
+// pseudonymizer requests per collection.
+type RunStatus struct {
+	Project  string                `json:"project"`
+	State    string                `json:"state"`
+	Start    time.Time             `json:"start"`
+	End      time.Time             `json:"end"`
+	Duration string                `json:"duration"`
+	Count    map[string]int        `json:"count"`
+	Retries  map[string]RetryStats `json:"retries,omitempty"`
+	Error    string                `json:"error,omitempty"`
+	// err is the error of the run, not saved
+	err error
+}
+
+func NewRunStatus(project string, start time.Time, result ProcessResult, err error) RunStatus {
+	status := RunStatus{
+		Project:  project,
+		State:    StateCompleted,
+		Start:    start,
+		End:      time.Now(),
+		Duration: result.duration.String(),
+		Count:    result.count,
+		Retries:  result.retries,
+	}
+
+	if err != nil {
+		status.State = StateFailed
+		if errors.Is(err, context.Canceled) {
+			status.State = StateInterrupted
+		}
+		status.Error = err.Error()
+		status.err = err
+	}
+
+	return status
+}
+
+// Save logs the status and writes it as JSON to file, if set
+func (s RunStatus) Save(file string) error {
+	slog.Info("Run status", "project", s.Project, "state", s.State, "duration", s.Duration,
+		"count", convertToString(s.Count), "retries", convertToString(s.retryCount()), "error", s.Error)
+
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0600)
+}
+
+// Report is the combined status of the runs of multiple projects
+type Report struct {
+	State    string      `json:"state"`
+	Start    time.Time   `json:"start"`
+	End      time.Time   `json:"end"`
+	Duration string      `json:"duration"`
+	Projects []RunStatus `json:"projects"`
+}
+
+// NewReport combines the status of the projects. The report failed if any
+// project failed, otherwise it's interrupted if any project was interrupted.
+func NewReport(start time.Time, projects []RunStatus) Report {
+	end := time.Now()
+	report := Report{
+		State:    StateCompleted,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start).String(),
+		Projects: projects,
+	}
+
+	for _, p := range projects {
+		switch {
+		case p.State == StateFailed:
+			report.State = StateFailed
+		case p.State == StateInterrupted && report.State == StateCompleted:
+			report.State = StateInterrupted
+		}
+	}
+
+	return report
+}
+
+// Save logs the report and writes it as JSON to file, if set
+func (r Report) Save(file string) error {
+	completed := 0
+	for _, p := range r.Projects {
+		if p.State == StateCompleted {
+			completed++
+		}
+	}
+	slog.Info("Run report", "state", r.State, "duration", r.Duration, "projects", len(r.Projects), "completed", completed)
+
+	if file == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, data, 0600)
+}
+
+// Err returns an error listing the projects that didn't complete. If none
+// failed, the error is context.Canceled, otherwise it wraps the errors of the
+// failed projects.
+func (r Report) Err() error {
+	var incomplete []string
+	var errs []error
+	for _, p := range r.Projects {
+		if p.State != StateCompleted {
+			incomplete = append(incomplete, fmt.Sprintf("%s (%s)", p.Project, p.State))
+		}
+		if p.State == StateFailed && p.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Project, p.err))
+		}
+	}
+	if len(incomplete) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%d of %d projects did not complete: %s", len(incomplete), len(r.Projects), strings.Join(incomplete, ", "))
+	if r.State == StateInterrupted {
+		return fmt.Errorf("%s: %w", msg, context.Canceled)
+	}
+	return errors.Join(append([]error{errors.New(msg)}, errs...)...)
+}
+
+// retryCount returns the total retries per collection
+func (s RunStatus) retryCount() map[string]int {
+	count := make(map[string]int, len(s.Retries))
+	for c, r := range s.Retries {
+		count[c] = r.Retries
+	}
+	return count
+}
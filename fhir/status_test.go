@@ -0,0 +1,123 @@
+package fhir
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewRunStatus(t *testing.T) {
+
+	cases := []struct {
+		err   error
+		state string
+	}{
+		{nil, StateCompleted},
+		{errors.New("missing gPAS domains: test-patient"), StateFailed},
+		{fmt.Errorf("processing interrupted: %w", context.Canceled), StateInterrupted},
+	}
+
+	for _, c := range cases {
+		status := NewRunStatus("test", time.Now(), ProcessResult{count: map[string]int{"Patient": 1}}, c.err)
+
+		assert.Equal(t, c.state, status.State)
+		if c.err != nil {
+			assert.Equal(t, c.err.Error(), status.Error)
+		}
+	}
+}
+
+func TestRunStatusSave(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "status.json")
+	status := NewRunStatus("test", time.Now(), ProcessResult{
+		count:    map[string]int{"Patient": 1},
+		retries:  map[string]RetryStats{"Patient": {Resources: 1, Retries: 2, Max: 2}},
+		duration: time.Second,
+	}, nil)
+
+	err := status.Save(file)
+	assert.Nil(t, err)
+
+	data, _ := os.ReadFile(file)
+	var saved RunStatus
+	_ = json.Unmarshal(data, &saved)
+
+	assert.Equal(t, "test", saved.Project)
+	assert.Equal(t, StateCompleted, saved.State)
+	assert.Equal(t, "1s", saved.Duration)
+	assert.Equal(t, RetryStats{Resources: 1, Retries: 2, Max: 2}, saved.Retries["Patient"])
+	assert.Equal(t, map[string]int{"Patient": 1}, saved.Count)
+}
+
+func TestNewReport(t *testing.T) {
+
+	completed := RunStatus{Project: "a", State: StateCompleted}
+	interrupted := RunStatus{Project: "b", State: StateInterrupted}
+	failed := RunStatus{Project: "c", State: StateFailed}
+
+	cases := []struct {
+		projects []RunStatus
+		state    string
+		err      string
+	}{
+		{[]RunStatus{completed, completed}, StateCompleted, ""},
+		{[]RunStatus{completed, interrupted}, StateInterrupted, "1 of 2 projects did not complete: b (interrupted): context canceled"},
+		{[]RunStatus{failed, interrupted, completed}, StateFailed, "2 of 3 projects did not complete: c (failed), b (interrupted)"},
+	}
+
+	for _, c := range cases {
+		report := NewReport(time.Now(), c.projects)
+
+		assert.Equal(t, c.state, report.State)
+		if c.err == "" {
+			assert.NoError(t, report.Err())
+		} else {
+			assert.EqualError(t, report.Err(), c.err)
+			assert.Equal(t, c.state == StateInterrupted, errors.Is(report.Err(), context.Canceled))
+		}
+	}
+}
+
+func TestReportErrWrapsProjectErrors(t *testing.T) {
+
+	report := NewReport(time.Now(), []RunStatus{
+		NewRunStatus("a", time.Now(), ProcessResult{}, fmt.Errorf("%w: source database a does not exist", ErrCheckFailed)),
+		NewRunStatus("b", time.Now(), ProcessResult{}, fmt.Errorf("processing interrupted: %w", context.Canceled)),
+		NewRunStatus("c", time.Now(), ProcessResult{}, nil),
+	})
+
+	err := report.Err()
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	// the failed project takes precedence over the interrupted one
+	assert.NotErrorIs(t, err, context.Canceled)
+	assert.EqualError(t, err, "2 of 3 projects did not complete: a (failed), b (interrupted)\n"+
+		"a: preflight check failed: source database a does not exist")
+}
+
+func TestReportSave(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "report.json")
+	report := NewReport(time.Now(), []RunStatus{
+		{Project: "a", State: StateCompleted, Count: map[string]int{"Patient": 1}},
+		{Project: "b", State: StateFailed, Error: "failed"},
+	})
+
+	assert.NoError(t, report.Save(file))
+
+	data, _ := os.ReadFile(file)
+	var saved Report
+	_ = json.Unmarshal(data, &saved)
+
+	assert.Equal(t, StateFailed, saved.State)
+	assert.Len(t, saved.Projects, 2)
+	assert.Equal(t, "b", saved.Projects[1].Project)
+	assert.Equal(t, "failed", saved.Projects[1].Error)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"log/slog"
+	"net/http"
+	"pseudonymous/config"
+	"strconv"
+	"time"
+)
+
+const namespace = "pseudonymous"
+
+var (
+	ResourcesRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resources_read_total",
+		Help:      "Number of resources read from the source database",
+	}, []string{"collection"})
+
+	ResourcesPseudonymized = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resources_pseudonymized_total",
+		Help:      "Number of resources pseudonymized by the FHIR Pseudonymizer",
+	}, []string{"collection"})
+
+	ResourcesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resources_written_total",
+		Help:      "Number of resources written to the destination database",
+	}, []string{"collection"})
+
+	ResourcesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "resources_failed_total",
+		Help:      "Number of resources failed to process by processing stage",
+	}, []string{"collection", "stage"})
+
+	PseudonymizerRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pseudonymizer_request_duration_seconds",
+		Help:      "Latency of FHIR Pseudonymizer requests including retries",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code"})
+
+	GpasRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gpas_request_duration_seconds",
+		Help:      "Latency of gPAS SOAP requests including retries",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code"})
+
+	RequestRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "request_retries_total",
+		Help:      "Number of retried requests by service",
+	}, []string{"service"})
+
+	WorkersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers_in_flight",
+		Help:      "Number of workers currently processing a resource",
+	})
+
+	WritersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "writers_in_flight",
+		Help:      "Number of writers currently writing a resource",
+	})
+
+	PseudonymizerConcurrencyLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pseudonymizer_concurrency_limit",
+		Help:      "Current limit of concurrent FHIR Pseudonymizer requests in adaptive mode",
+	})
+
+	QueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_length",
+		Help:      "Number of resources waiting in a queue between processing stages",
+	}, []string{"queue"})
+
+	StageBlocked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stage_blocked_seconds_total",
+		Help:      "Time a processing stage waited for the next stage to accept a resource",
+	}, []string{"stage"})
+)
+
+// Code returns the status code label of a request's result
+func Code(statusCode int, err error) string {
+	if err != nil && statusCode == 0 {
+		return "error"
+	}
+	return strconv.Itoa(statusCode)
+}
+
+// Since observes the duration since start with the given status code label
+func Since(h *prometheus.HistogramVec, code string, start time.Time) {
+	h.WithLabelValues(code).Observe(time.Since(start).Seconds())
+}
+
+// Serve starts the metrics endpoint in the background if enabled. The
+// returned server is nil otherwise.
+func Serve(cfg config.Metrics) *http.Server {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = "/metrics"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Serving metrics", "address", cfg.Address, "path", path)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to serve metrics", "address", cfg.Address, "error", err.Error())
+		}
+	}()
+
+	return srv
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"pseudonymous/config"
+	"testing"
+	"time"
+)
+
+func TestServeDisabled(t *testing.T) {
+
+	srv := Serve(config.Metrics{Enabled: false})
+
+	assert.Nil(t, srv)
+}
+
+func TestServe(t *testing.T) {
+
+	// get free port
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	srv := Serve(config.Metrics{Enabled: true, Address: addr})
+	defer func() { _ = srv.Close() }()
+
+	WorkersInFlight.Set(3)
+
+	var resp *http.Response
+	assert.Eventually(t, func() bool {
+		var err error
+		resp, err = http.Get("http://" + addr + "/metrics")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer func() { _ = resp.Body.Close() }()
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), "pseudonymous_workers_in_flight 3")
+}
+
+func TestCode(t *testing.T) {
+
+	assert.Equal(t, "200", Code(http.StatusOK, nil))
+	assert.Equal(t, "503", Code(http.StatusServiceUnavailable, nil))
+	assert.Equal(t, "error", Code(0, errors.New("connection refused")))
+}
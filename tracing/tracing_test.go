@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"pseudonymous/config"
+	"testing"
+)
+
+func TestConfigureDisabled(t *testing.T) {
+
+	shutdown, err := Configure(config.Tracing{Enabled: false})
+
+	assert.Nil(t, err)
+	assert.Nil(t, shutdown(context.Background()))
+}
+
+func TestConfigureStdout(t *testing.T) {
+
+	shutdown, err := Configure(config.Tracing{Enabled: true, Exporter: "stdout"})
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	assert.Nil(t, err)
+	assert.Nil(t, shutdown(context.Background()))
+}
+
+func TestConfigureUnknownExporter(t *testing.T) {
+
+	_, err := Configure(config.Tracing{Enabled: true, Exporter: "foo"})
+
+	assert.EqualError(t, err, "unknown trace exporter foo")
+}
+
+func TestInject(t *testing.T) {
+	_, _ = Configure(config.Tracing{})
+	tp := sdktrace.NewTracerProvider()
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	header := http.Header{}
+	Inject(ctx, header)
+
+	assert.Contains(t, header.Get("traceparent"), span.SpanContext().TraceID().String())
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	_, span := tp.Tracer("test").Start(context.Background(), "test")
+	End(span, errors.New("failed"))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "failed", spans[0].Status().Description)
+}
@@ -1,22 +1,136 @@
 package ttp
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"github.com/go-resty/resty/v2"
 	"io"
 	"log/slog"
 	"net/http"
 	"pseudonymous/config"
+	"pseudonymous/metrics"
+	"pseudonymous/tracing"
 	"strings"
+	"time"
 )
 
+// ErrMissingDomains is returned if required domains don't exist in gPAS
+var ErrMissingDomains = errors.New("missing gPAS domains")
+
 type GpasClient struct {
 	Config config.Gpas
+	rest   *resty.Client
 }
 
 func NewGpasClient(cfg config.Gpas) *GpasClient {
-	return &GpasClient{Config: cfg}
+	limiter := cfg.RateLimit.Limiter()
+	client := resty.New().
+		SetLogger(config.DefaultLogger()).
+		SetRetryCount(cfg.Retry.Count).
+		SetTimeout(time.Duration(cfg.Retry.Timeout) * time.Second).
+		SetRetryWaitTime(time.Duration(cfg.Retry.Wait) * time.Second).
+		SetRetryMaxWaitTime(time.Duration(cfg.Retry.MaxWait) * time.Second).
+		AddRetryCondition(retryable).
+		AddRetryHook(func(resp *resty.Response, err error) {
+			metrics.RequestRetries.WithLabelValues("gpas").Inc()
+			// no response if the request failed before it was sent
+			if resp == nil {
+				slog.Warn("gPAS request failed, retrying", "error", err)
+				return
+			}
+			slog.Warn("gPAS request failed, retrying", "url", config.RedactUrl(resp.Request.URL), "status", resp.Status(), "error", err)
+		}).
+		OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+			// applies to retries as well
+			return limiter.Wait(r.Context())
+		}).
+		OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+			tracing.Inject(r.Context(), r.Header)
+			return nil
+		})
+
+	var tlsConfig *tls.Config
+	if cfg.Tls != nil {
+		var err error
+		tlsConfig, err = cfg.Tls.ClientConfig()
+		if err != nil {
+			slog.Error("Failed to configure TLS for the gPAS client", "error", err.Error())
+			return nil
+		}
+		client = client.SetTLSClientConfig(tlsConfig)
+	}
+
+	if cfg.Auth != nil {
+		if cfg.Auth.Basic != nil {
+			client = client.SetBasicAuth(cfg.Auth.Basic.Username, cfg.Auth.Basic.Password)
+		}
+		if cfg.Auth.OAuth2 != nil {
+			tokens := cfg.Auth.OAuth2.TokenSource(tlsConfig)
+			client = client.OnBeforeRequest(func(_ *resty.Client, r *resty.Request) error {
+				token, err := tokens.Token()
+				if err != nil {
+					return fmt.Errorf("failed to get access token for gPAS: %w", err)
+				}
+				r.SetAuthScheme(token.Type()).SetAuthToken(token.AccessToken)
+				return nil
+			})
+		}
+	}
+
+	return &GpasClient{Config: cfg, rest: client}
+}
+
+// SoapError is returned for SOAP requests with an unsuccessful response status
+type SoapError struct {
+	StatusCode int
+	Fault      *Fault
+}
+
+func (e *SoapError) Error() string {
+	if e.Fault != nil {
+		return fmt.Sprintf("soap request failed with status code %d: %s", e.StatusCode, e.Fault.FaultString)
+	}
+	return fmt.Sprintf("soap request failed with status code %d", e.StatusCode)
+}
+
+// Retryable reports whether the error is caused by a temporarily unavailable
+// service. SOAP faults are fatal.
+func (e *SoapError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusInternalServerError:
+		return e.Fault == nil
+	}
+	return false
+}
+
+func newSoapError(resp *resty.Response) *SoapError {
+	soapErr := &SoapError{StatusCode: resp.StatusCode()}
+
+	var fault FaultEnvelope
+	if err := xml.Unmarshal(resp.Body(), &fault); err == nil && fault.Body.Fault.FaultString != "" {
+		soapErr.Fault = &fault.Body.Fault
+	}
+
+	return soapErr
+}
+
+// retryable is the retry condition for gPAS requests: connection errors and
+// retryable SOAP errors. Errors of cancelled requests and of the before-request
+// hooks (without response) are final.
+func retryable(resp *resty.Response, err error) bool {
+	if err != nil {
+		return config.TransportError(resp, err)
+	}
+	if resp.StatusCode() == http.StatusOK {
+		return false
+	}
+	return newSoapError(resp).Retryable()
 }
 
 type AddDomainEnvelope struct {
@@ -40,7 +154,8 @@ type DomainDTO struct {
 	Label             string       `xml:"label"`
 	CheckDigitClass   string       `xml:"checkDigitClass"`
 	Alphabet          string       `xml:"alphabet"`
-	ParentDomainNames string       `xml:"parentDomainNames,omitempty"`
+	ParentDomainNames []string     `xml:"parentDomainNames,omitempty"`
+	Comment           string       `xml:"comment,omitempty"`
 	Config            DomainConfig `xml:"config"`
 }
 
@@ -50,6 +165,23 @@ type DomainConfig struct {
 	PsnsDeletable bool   `xml:"psnsDeletable"`
 }
 
+type GetDomainEnvelope struct {
+	XMLName xml.Name      `xml:"soap:Envelope"`
+	XMLNSs  string        `xml:"xmlns:soap,attr"`
+	Psn     string        `xml:"xmlns:psn,attr"`
+	Header  string        `xml:"soap:Header"`
+	Body    GetDomainBody `xml:"soap:Body"`
+}
+
+type GetDomainBody struct {
+	XMLName   xml.Name  `xml:"soap:Body"`
+	GetDomain GetDomain `xml:"psn:getDomain"`
+}
+
+type GetDomain struct {
+	DomainName string `xml:"domainName"`
+}
+
 type FaultEnvelope struct {
 	XMLName xml.Name  `xml:"Envelope"`
 	Body    FaultBody `xml:"Body"`
@@ -61,21 +193,32 @@ type FaultBody struct {
 }
 
 type Fault struct {
-	FaultCode   string `xml:"faultcode"`
-	FaultString string `xml:"faultstring"`
+	FaultCode   string       `xml:"faultcode"`
+	FaultString string       `xml:"faultstring"`
+	Detail      *FaultDetail `xml:"detail,omitempty"`
+}
+
+// FaultDetail is the exception of a SOAP fault
+type FaultDetail struct {
+	UnknownDomain *struct{} `xml:"UnknownDomainException,omitempty"`
 }
 
-func (c *GpasClient) SetupDomains(project string) error {
-	// project parent domain
-	domainConfig := createDomainDto(project, "", "")
-	if err := c.send(domainConfig); err != nil {
-		slog.Error("Failed to create gPAS domain", "domain", domainConfig.Name, "error", err)
+// UnknownDomain reports whether the fault is caused by a domain that doesn't
+// exist in gPAS
+func (f *Fault) UnknownDomain() bool {
+	return (f.Detail != nil && f.Detail.UnknownDomain != nil) ||
+		strings.Contains(f.FaultString, "UnknownDomainException")
+}
+
+func (c *GpasClient) SetupDomains(ctx context.Context, project string) error {
+	domains, err := c.Domains(project)
+	if err != nil {
+		slog.Error("Invalid gPAS domain configuration", "error", err)
 		return err
 	}
 
-	for domain, prefix := range c.Config.Domains.Config {
-		domainConfig = createDomainDto(project, domain, prefix)
-		if err := c.send(domainConfig); err != nil {
+	for _, domainConfig := range domains {
+		if err = c.send(ctx, domainConfig); err != nil {
 			slog.Error("Failed to create gPAS domain", "domain", domainConfig.Name, "error", err)
 			return err
 		}
@@ -84,23 +227,168 @@ func (c *GpasClient) SetupDomains(project string) error {
 	return nil
 }
 
-func createDomainDto(project string, idType string, prefix string) DomainDTO {
+// VerifyDomains checks that all domains exist in gPAS and returns an error
+// listing the missing ones
+func (c *GpasClient) VerifyDomains(ctx context.Context, names []string) error {
+	var missing []string
+	for _, name := range names {
+		exists, err := c.DomainExists(ctx, name)
+		if err != nil {
+			slog.Error("Failed to verify gPAS domain", "domain", name, "error", err)
+			return err
+		}
+		if !exists {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s", ErrMissingDomains, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// DomainExists checks if a domain exists in gPAS. Unknown domains are
+// reported with an UnknownDomainException fault, all other faults are errors.
+func (c *GpasClient) DomainExists(ctx context.Context, name string) (bool, error) {
+	soap := GetDomainEnvelope{
+		XMLNSs: "http://schemas.xmlsoap.org/soap/envelope/",
+		Psn:    "http://psn.ttp.ganimed.icmvc.emau.org/",
+		Body: GetDomainBody{
+			GetDomain: GetDomain{DomainName: name},
+		},
+	}
+
+	_, err := c.post(ctx, c.Config.Url, "getDomain", &soap)
+
+	var soapErr *SoapError
+	if errors.As(err, &soapErr) && soapErr.Fault != nil && soapErr.Fault.UnknownDomain() {
+		slog.Debug("gPAS domain not found", "domain", name, "fault", soapErr.Fault.FaultString)
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Check requests the configured gPAS service URLs (i.e. their WSDL), without
+// retries
+func (c *GpasClient) Check(ctx context.Context) error {
+	rest := c.rest.Clone().SetRetryCount(0)
+
+	var errs []error
+	for _, u := range []string{c.Config.Url, c.Config.PsnUrl} {
+		if u == "" {
+			continue
+		}
+		resp, err := rest.R().SetContext(ctx).Get(u)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to reach gPAS at %s: %w", config.RedactUrl(u), err))
+			continue
+		}
+		if !resp.IsSuccess() {
+			errs = append(errs, fmt.Errorf("gPAS request to %s returned %s", config.RedactUrl(u), resp.Status()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Domains returns the configured domains of a project in dependency order,
+// starting with the project parent domain. Parent domains are always listed
+// before their children.
+func (c *GpasClient) Domains(project string) ([]DomainDTO, error) {
+	configs := make(map[string]config.Domain, len(c.Config.Domains.Config))
+	for _, d := range c.Config.Domains.Config {
+		if d.Name == "" {
+			return nil, errors.New("gPAS domain name is empty")
+		}
+		if _, exists := configs[d.Name]; exists {
+			return nil, fmt.Errorf("duplicate gPAS domain %s", d.Name)
+		}
+		configs[d.Name] = d
+	}
+
+	domains := []DomainDTO{createDomainDto(project, config.Domain{})}
+
+	// depth-first traversal of the parent relations
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(configs))
+	var visit func(d config.Domain) error
+	visit = func(d config.Domain) error {
+		switch state[d.Name] {
+		case visiting:
+			return fmt.Errorf("cyclic gPAS domain hierarchy at domain %s", d.Name)
+		case visited:
+			return nil
+		}
+
+		state[d.Name] = visiting
+		for _, p := range d.Parents {
+			parent, exists := configs[p]
+			if !exists {
+				return fmt.Errorf("unknown parent domain %s of gPAS domain %s", p, d.Name)
+			}
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		state[d.Name] = visited
+
+		domains = append(domains, createDomainDto(project, d))
+		return nil
+	}
+
+	for _, d := range c.Config.Domains.Config {
+		if err := visit(d); err != nil {
+			return nil, err
+		}
+	}
+
+	return domains, nil
+}
+
+// createDomainDto creates the gPAS domain of a domain definition. Names of the
+// domain and its parents are prefixed with the project name. Domains without
+// parents are children of the project domain. The empty domain definition
+// denotes the project domain itself.
+func createDomainDto(project string, domain config.Domain) DomainDTO {
 
 	name := project
-	var parent string
+	var parents []string
 	psnPrefix := fmt.Sprintf("PSN-%s-", strings.ToUpper(project))
-	if idType != "" {
-		name += fmt.Sprintf("-%s", idType)
+	if domain.Name != "" {
+		name += fmt.Sprintf("-%s", domain.Name)
+
+		prefix := domain.Prefix
+		if prefix == "" {
+			prefix = domain.Name
+		}
 		psnPrefix += fmt.Sprintf("%s-", strings.ToUpper(prefix))
-		parent = project
+
+		parents = []string{project}
+		if len(domain.Parents) > 0 {
+			parents = make([]string, 0, len(domain.Parents))
+			for _, p := range domain.Parents {
+				parents = append(parents, fmt.Sprintf("%s-%s", project, p))
+			}
+		}
+	}
+
+	label := domain.Label
+	if label == "" {
+		label = name
 	}
 
 	return DomainDTO{
 		Name:              name,
-		Label:             name,
+		Label:             label,
 		CheckDigitClass:   "org.emau.icmvc.ganimed.ttp.psn.generator.NoCheckDigits",
 		Alphabet:          "org.emau.icmvc.ganimed.ttp.psn.alphabets.Symbol32",
-		ParentDomainNames: parent,
+		ParentDomainNames: parents,
+		Comment:           domain.Comment,
 		Config: DomainConfig{
 			PsnLength:     16,
 			PsnPrefix:     psnPrefix,
@@ -109,7 +397,7 @@ func createDomainDto(project string, idType string, prefix string) DomainDTO {
 	}
 }
 
-func (c *GpasClient) send(domainConfig DomainDTO) error {
+func (c *GpasClient) send(ctx context.Context, domainConfig DomainDTO) error {
 
 	soap := AddDomainEnvelope{
 		XMLNSs: "http://schemas.xmlsoap.org/soap/envelope/",
@@ -119,47 +407,48 @@ func (c *GpasClient) send(domainConfig DomainDTO) error {
 		},
 	}
 
-	body, err := xml.MarshalIndent(&soap, " ", "  ")
-	if err != nil {
-		return err
-	}
+	_, err := c.post(ctx, c.Config.Url, "addDomain", &soap)
 
-	// send soap request
-	req, err := http.NewRequest(http.MethodPost, c.Config.Url, bytes.NewBufferString(string(body)))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "text/xml")
-	if c.Config.Auth != nil && c.Config.Auth.Basic != nil {
-		req.SetBasicAuth(c.Config.Auth.Basic.Username, c.Config.Auth.Basic.Password)
+	var soapErr *SoapError
+	if errors.As(err, &soapErr) && soapErr.Fault != nil && c.Config.Domains.UseExisting {
+		if soapErr.Fault.FaultString == fmt.Sprintf("domain %s already exists", domainConfig.Name) {
+			slog.Warn("Reusing existing domain", "domain", domainConfig.Name)
+			return nil
+		}
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if resp != nil && resp.StatusCode != http.StatusOK {
-
-		if resp.StatusCode == http.StatusInternalServerError && c.Config.Domains.UseExisting {
-			// check response body
-			defer closeBody(resp.Body)
+	return err
+}
 
-			respBody, _ := io.ReadAll(resp.Body)
+// post sends a SOAP request of an operation and returns the response body.
+// Requests are retried on connection errors and retryable SOAP errors.
+func (c *GpasClient) post(ctx context.Context, url string, operation string, envelope interface{}) (respBody []byte, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "gpas "+operation)
+	defer func() { tracing.End(span, err) }()
 
-			// parse soap response
-			var fault FaultEnvelope
-			err = xml.Unmarshal(respBody, &fault)
-			if err != nil {
-				return err
-			}
+	body, err := xml.MarshalIndent(envelope, " ", "  ")
+	if err != nil {
+		return nil, err
+	}
 
-			if fault.Body.Fault.FaultString == fmt.Sprintf("domain %s already exists", domainConfig.Name) {
-				slog.Warn("Reusing existing domain", "domain", domainConfig.Name)
-				return nil
-			}
-		}
+	// send soap request
+	start := time.Now()
+	resp, err := c.rest.R().
+		SetContext(ctx).
+		SetBody(body).
+		SetHeader("Content-Type", "text/xml").
+		Post(url)
+	if err != nil {
+		metrics.Since(metrics.GpasRequestDuration, metrics.Code(0, err), start)
+		return nil, err
+	}
+	metrics.Since(metrics.GpasRequestDuration, metrics.Code(resp.StatusCode(), nil), start)
 
-		err = fmt.Errorf("soap request failed with status code %d", resp.StatusCode)
+	if resp.StatusCode() != http.StatusOK {
+		return nil, newSoapError(resp)
 	}
 
-	return err
+	return resp.Body(), nil
 }
 
 func closeBody(body io.ReadCloser) {
@@ -1,15 +1,21 @@
 package ttp
 
 import (
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"github.com/go-resty/resty/v2"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"pseudonymous/config"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSetupDomains(t *testing.T) {
@@ -19,18 +25,18 @@ func TestSetupDomains(t *testing.T) {
 	}))
 	defer s.Close()
 
-	client := GpasClient{Config: config.Gpas{
+	client := NewGpasClient(config.Gpas{
 		Url: s.URL,
 		Domains: config.Domains{
-			Config: map[string]string{
-				"foo": "bar",
-				"bla": "blubb",
+			Config: []config.Domain{
+				{Name: "foo", Prefix: "bar"},
+				{Name: "bla", Prefix: "blubb"},
 			},
 		},
-	}}
+	})
 	project := "test"
 
-	err := client.SetupDomains(project)
+	err := client.SetupDomains(context.Background(), project)
 
 	assert.Nil(t, err)
 }
@@ -71,18 +77,387 @@ func TestSetupDomainsExist(t *testing.T) {
 	}))
 	defer s.Close()
 
-	client := GpasClient{Config: config.Gpas{
+	client := NewGpasClient(config.Gpas{
 		Url: s.URL,
 		Domains: config.Domains{
 			UseExisting: true,
-			Config: map[string]string{
-				"foo": "bar",
-				"bla": "blubb",
+			Config: []config.Domain{
+				{Name: "foo", Prefix: "bar"},
+				{Name: "bla", Prefix: "blubb"},
 			},
 		},
-	}}
+	})
 
-	err := client.SetupDomains(project)
+	err := client.SetupDomains(context.Background(), project)
 
 	assert.Nil(t, err)
 }
+
+func TestDomains(t *testing.T) {
+
+	client := NewGpasClient(config.Gpas{
+		Domains: config.Domains{
+			Config: []config.Domain{
+				{Name: "siteA-patient", Prefix: "SA-PAT", Parents: []string{"siteA", "patient"}, Comment: "site A patients"},
+				{Name: "siteA", Label: "Site A"},
+				{Name: "patient", Prefix: "PATIENT"},
+			},
+		},
+	})
+
+	domains, err := client.Domains("study")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []DomainDTO{
+		createDomainDto("study", config.Domain{}),
+		{
+			Name:              "study-siteA",
+			Label:             "Site A",
+			CheckDigitClass:   "org.emau.icmvc.ganimed.ttp.psn.generator.NoCheckDigits",
+			Alphabet:          "org.emau.icmvc.ganimed.ttp.psn.alphabets.Symbol32",
+			ParentDomainNames: []string{"study"},
+			Config:            DomainConfig{PsnLength: 16, PsnPrefix: "PSN-STUDY-SITEA-"},
+		},
+		{
+			Name:              "study-patient",
+			Label:             "study-patient",
+			CheckDigitClass:   "org.emau.icmvc.ganimed.ttp.psn.generator.NoCheckDigits",
+			Alphabet:          "org.emau.icmvc.ganimed.ttp.psn.alphabets.Symbol32",
+			ParentDomainNames: []string{"study"},
+			Config:            DomainConfig{PsnLength: 16, PsnPrefix: "PSN-STUDY-PATIENT-"},
+		},
+		{
+			Name:              "study-siteA-patient",
+			Label:             "study-siteA-patient",
+			CheckDigitClass:   "org.emau.icmvc.ganimed.ttp.psn.generator.NoCheckDigits",
+			Alphabet:          "org.emau.icmvc.ganimed.ttp.psn.alphabets.Symbol32",
+			ParentDomainNames: []string{"study-siteA", "study-patient"},
+			Comment:           "site A patients",
+			Config:            DomainConfig{PsnLength: 16, PsnPrefix: "PSN-STUDY-SA-PAT-"},
+		},
+	}, domains)
+}
+
+func TestDomainsInvalid(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		domains []config.Domain
+		err     string
+	}{
+		{
+			name:    "unknown parent",
+			domains: []config.Domain{{Name: "foo", Parents: []string{"bar"}}},
+			err:     "unknown parent domain bar of gPAS domain foo",
+		},
+		{
+			name: "cycle",
+			domains: []config.Domain{
+				{Name: "foo", Parents: []string{"bar"}},
+				{Name: "bar", Parents: []string{"foo"}},
+			},
+			err: "cyclic gPAS domain hierarchy at domain foo",
+		},
+		{
+			name:    "duplicate",
+			domains: []config.Domain{{Name: "foo"}, {Name: "foo"}},
+			err:     "duplicate gPAS domain foo",
+		},
+		{
+			name:    "empty name",
+			domains: []config.Domain{{Prefix: "FOO"}},
+			err:     "gPAS domain name is empty",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := NewGpasClient(config.Gpas{Domains: config.Domains{Config: c.domains}})
+
+			_, err := client.Domains("test")
+
+			assert.EqualError(t, err, c.err)
+		})
+	}
+}
+
+func TestSetupDomainsOrder(t *testing.T) {
+
+	var created []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer closeBody(r.Body)
+
+		reqBody, _ := io.ReadAll(r.Body)
+		re := regexp.MustCompile(`<name>(.*)</name>`)
+		created = append(created, re.FindStringSubmatch(string(reqBody))[1])
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{
+		Url: s.URL,
+		Domains: config.Domains{
+			Config: []config.Domain{
+				{Name: "patient"},
+				{Name: "encounter"},
+				{Name: "case", Parents: []string{"visit"}},
+				{Name: "visit"},
+			},
+		},
+	})
+
+	err := client.SetupDomains(context.Background(), "test")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"test", "test-patient", "test-encounter", "test-visit", "test-case"}, created)
+}
+
+func TestSetupDomainsRetry(t *testing.T) {
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{
+		Url:   s.URL,
+		Retry: config.Retry{Count: 3},
+	})
+	client.rest.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(time.Millisecond)
+
+	err := client.SetupDomains(context.Background(), "test")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestSetupDomainsRateLimitedCancel(t *testing.T) {
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{
+		Url:       s.URL,
+		Domains:   config.Domains{Config: []config.Domain{{Name: "patient"}}},
+		Retry:     config.Retry{Count: 1},
+		RateLimit: config.RateLimit{Rate: 0.01, Burst: 1},
+	})
+	client.rest.SetRetryWaitTime(time.Millisecond).SetRetryMaxWaitTime(time.Millisecond)
+
+	// the project domain takes the only token, cancel while waiting for the next
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := client.SetupDomains(ctx, "test")
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSetupDomainsFault(t *testing.T) {
+
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+
+		w.WriteHeader(http.StatusInternalServerError)
+		res := FaultEnvelope{
+			XMLName: xml.Name{Local: "Envelope"},
+			Body: FaultBody{
+				XMLName: xml.Name{Local: "Body"},
+				Fault:   Fault{FaultCode: "soap:Server", FaultString: "invalid parameter"},
+			},
+		}
+		resBody, _ := xml.Marshal(res)
+		_, _ = w.Write(resBody)
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{
+		Url:   s.URL,
+		Retry: config.Retry{Count: 3},
+	})
+
+	err := client.SetupDomains(context.Background(), "test")
+
+	// soap faults are not retried
+	var soapErr *SoapError
+	assert.ErrorAs(t, err, &soapErr)
+	assert.False(t, soapErr.Retryable())
+	assert.Equal(t, 1, requests)
+}
+
+func TestSoapErrorRetryable(t *testing.T) {
+
+	cases := []struct {
+		err       SoapError
+		retryable bool
+	}{
+		{SoapError{StatusCode: http.StatusServiceUnavailable}, true},
+		{SoapError{StatusCode: http.StatusTooManyRequests}, true},
+		{SoapError{StatusCode: http.StatusInternalServerError}, true},
+		{SoapError{StatusCode: http.StatusInternalServerError, Fault: &Fault{FaultString: "error"}}, false},
+		{SoapError{StatusCode: http.StatusBadRequest}, false},
+		{SoapError{StatusCode: http.StatusUnauthorized}, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.retryable, c.err.Retryable(), c.err.Error())
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	req := resty.New().R()
+	connErr := &url.Error{Op: "Post", URL: "http://localhost", Err: errors.New("connection refused")}
+
+	assert.True(t, retryable(&resty.Response{Request: req}, connErr))
+	assert.False(t, retryable(&resty.Response{Request: req}, errors.New("invalid request")))
+	assert.False(t, retryable(nil, errors.New("no token")))
+	assert.False(t, retryable(&resty.Response{Request: req}, context.Canceled))
+}
+
+func TestNewGpasClient(t *testing.T) {
+
+	c := config.Gpas{
+		Retry: config.Retry{
+			Count:   3,
+			Timeout: 5,
+			Wait:    5,
+			MaxWait: 15,
+		},
+		Auth: &config.Auth{
+			Basic: &config.Basic{
+				Username: "foo",
+				Password: "bar",
+			},
+		},
+	}
+
+	client := NewGpasClient(c)
+
+	assert.Equal(t, "foo", client.rest.UserInfo.Username)
+	assert.Equal(t, 3, client.rest.RetryCount)
+	assert.Equal(t, 5*time.Second, client.rest.GetClient().Timeout)
+	assert.Equal(t, 5*time.Second, client.rest.RetryWaitTime)
+	assert.Equal(t, 15*time.Second, client.rest.RetryMaxWaitTime)
+}
+
+func TestNewGpasClientInvalidTls(t *testing.T) {
+
+	client := NewGpasClient(config.Gpas{Tls: &config.Tls{CaFile: "./missing.pem"}})
+
+	assert.Nil(t, client)
+}
+
+func TestVerifyDomains(t *testing.T) {
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer closeBody(r.Body)
+
+		reqBody, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(reqBody), "<domainName>test-patient</domainName>") {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>
+<soap:Fault><faultcode>soap:Server</faultcode><faultstring>domain not found</faultstring>
+<detail><ns2:UnknownDomainException xmlns:ns2="http://psn.ttp.ganimed.icmvc.emau.org/"/></detail>
+</soap:Fault></soap:Body></soap:Envelope>`))
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{Url: s.URL})
+
+	assert.Nil(t, client.VerifyDomains(context.Background(), []string{"test-patient"}))
+	assert.EqualError(t, client.VerifyDomains(context.Background(), []string{"test-patient", "test-foo", "test-bar"}),
+		"missing gPAS domains: test-foo, test-bar")
+}
+
+func TestVerifyDomainsFault(t *testing.T) {
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>
+<soap:Fault><faultcode>soap:Server</faultcode><faultstring>access denied</faultstring></soap:Fault>
+</soap:Body></soap:Envelope>`))
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{Url: s.URL})
+
+	exists, err := client.DomainExists(context.Background(), "test-patient")
+
+	assert.False(t, exists)
+	assert.EqualError(t, err, "soap request failed with status code 500: access denied")
+	assert.EqualError(t, client.VerifyDomains(context.Background(), []string{"test-patient"}),
+		"soap request failed with status code 500: access denied")
+}
+
+func TestGpasClientOAuth2(t *testing.T) {
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"secret-token","token_type":"Bearer","expires_in":300}`))
+	}))
+	defer tokens.Close()
+
+	var auth string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{Url: s.URL, Auth: &config.Auth{
+		OAuth2: &config.OAuth2{TokenUrl: tokens.URL, ClientId: "test", ClientSecret: "secret"},
+	}})
+
+	exists, err := client.DomainExists(context.Background(), "test-patient")
+
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "Bearer secret-token", auth)
+}
+
+func TestGpasClientOAuth2TokenError(t *testing.T) {
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer tokens.Close()
+
+	client := NewGpasClient(config.Gpas{Url: "http://localhost", Retry: config.Retry{Count: 1}, Auth: &config.Auth{
+		OAuth2: &config.OAuth2{TokenUrl: tokens.URL, ClientId: "test", ClientSecret: "wrong"},
+	}})
+
+	_, err := client.DomainExists(context.Background(), "test-patient")
+
+	assert.ErrorContains(t, err, "failed to get access token for gPAS")
+}
+
+func TestGpasClientCheck(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/gpas/gpasService" {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{Url: s.URL + "/gpas/DomainService?wsdl"})
+	assert.NoError(t, client.Check(context.Background()))
+
+	client = NewGpasClient(config.Gpas{Url: s.URL + "/gpas/DomainService?wsdl", PsnUrl: s.URL + "/gpas/gpasService?wsdl"})
+	err := client.Check(context.Background())
+	assert.EqualError(t, err, fmt.Sprintf("gPAS request to %s/gpas/gpasService?wsdl returned 404 Not Found", s.URL))
+}
@@ -0,0 +1,77 @@
+package ttp
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+)
+
+type GetValueForListEnvelope struct {
+	XMLName xml.Name            `xml:"soap:Envelope"`
+	XMLNSs  string              `xml:"xmlns:soap,attr"`
+	Psn     string              `xml:"xmlns:psn,attr"`
+	Header  string              `xml:"soap:Header"`
+	Body    GetValueForListBody `xml:"soap:Body"`
+}
+
+type GetValueForListBody struct {
+	XMLName         xml.Name        `xml:"soap:Body"`
+	GetValueForList GetValueForList `xml:"psn:getValueForList"`
+}
+
+type GetValueForList struct {
+	PsnList    []string `xml:"psnList"`
+	DomainName string   `xml:"domainName"`
+}
+
+type GetValueForListResponseEnvelope struct {
+	XMLName xml.Name                    `xml:"Envelope"`
+	Body    GetValueForListResponseBody `xml:"Body"`
+}
+
+type GetValueForListResponseBody struct {
+	XMLName  xml.Name                `xml:"Body"`
+	Response GetValueForListResponse `xml:"getValueForListResponse"`
+}
+
+type GetValueForListResponse struct {
+	Entries []MapEntry `xml:"return>entry"`
+}
+
+type MapEntry struct {
+	Key   string `xml:"key"`
+	Value string `xml:"value"`
+}
+
+// ResolvePseudonyms looks up the original values of the given pseudonyms in
+// a gPAS domain. The result maps each pseudonym to its original value.
+func (c *GpasClient) ResolvePseudonyms(ctx context.Context, domain string, psns []string) (map[string]string, error) {
+	if c.Config.PsnUrl == "" {
+		return nil, errors.New("gPAS psn-url is not configured")
+	}
+
+	soap := GetValueForListEnvelope{
+		XMLNSs: "http://schemas.xmlsoap.org/soap/envelope/",
+		Psn:    "http://psn.ttp.ganimed.icmvc.emau.org/",
+		Body: GetValueForListBody{
+			GetValueForList: GetValueForList{PsnList: psns, DomainName: domain},
+		},
+	}
+
+	respBody, err := c.post(ctx, c.Config.PsnUrl, "getValueForList", &soap)
+	if err != nil {
+		return nil, err
+	}
+
+	var result GetValueForListResponseEnvelope
+	if err = xml.Unmarshal(respBody, &result); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(result.Body.Response.Entries))
+	for _, e := range result.Body.Response.Entries {
+		values[e.Key] = e.Value
+	}
+
+	return values, nil
+}
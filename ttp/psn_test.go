@@ -0,0 +1,63 @@
+package ttp
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pseudonymous/config"
+	"strings"
+	"testing"
+)
+
+func TestResolvePseudonyms(t *testing.T) {
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer closeBody(r.Body)
+
+		reqBody, _ := io.ReadAll(r.Body)
+		assert.Contains(t, string(reqBody), "<psnList>PSN-1</psnList>")
+		assert.Contains(t, string(reqBody), "<domainName>test-patient</domainName>")
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+<soap:Body><ns2:getValueForListResponse xmlns:ns2="http://psn.ttp.ganimed.icmvc.emau.org/">
+<return><entry><key>PSN-1</key><value>1</value></entry><entry><key>PSN-2</key><value>2</value></entry></return>
+</ns2:getValueForListResponse></soap:Body></soap:Envelope>`))
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{PsnUrl: s.URL})
+
+	values, err := client.ResolvePseudonyms(context.Background(), "test-patient", []string{"PSN-1", "PSN-2"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"PSN-1": "1", "PSN-2": "2"}, values)
+}
+
+func TestResolvePseudonymsFault(t *testing.T) {
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(strings.TrimSpace(`
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault>
+<faultcode>soap:Server</faultcode><faultstring>value for psn PSN-1 not found</faultstring>
+</soap:Fault></soap:Body></soap:Envelope>`)))
+	}))
+	defer s.Close()
+
+	client := NewGpasClient(config.Gpas{PsnUrl: s.URL})
+
+	_, err := client.ResolvePseudonyms(context.Background(), "test-patient", []string{"PSN-1"})
+
+	assert.EqualError(t, err, "soap request failed with status code 500: value for psn PSN-1 not found")
+}
+
+func TestResolvePseudonymsNoUrl(t *testing.T) {
+	client := NewGpasClient(config.Gpas{})
+
+	_, err := client.ResolvePseudonyms(context.Background(), "test-patient", []string{"PSN-1"})
+
+	assert.EqualError(t, err, "gPAS psn-url is not configured")
+}